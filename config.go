@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/github.com/codegangsta/cli"
+	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/gopkg.in/yaml.v2"
+)
+
+// fileConfig is the layout of the file passed to run --config, settings left
+// out use the flag defaults
+type fileConfig struct {
+	DNS struct {
+		Name        string `yaml:"name"`
+		Address     string `yaml:"address"`
+		Port        string `yaml:"port"`
+		Network     string `yaml:"network"`
+		Compression bool   `yaml:"compression"`
+		Listeners   []struct {
+			Address  string   `yaml:"address"`
+			Networks []string `yaml:"networks"`
+			View     string   `yaml:"view"`
+		} `yaml:"listeners"`
+	} `yaml:"dns"`
+	TLS struct {
+		Cert       string `yaml:"cert"`
+		Key        string `yaml:"key"`
+		SelfSigned bool   `yaml:"self-signed"`
+	} `yaml:"tls"`
+	Timeouts struct {
+		Read     time.Duration `yaml:"read"`
+		Write    time.Duration `yaml:"write"`
+		Idle     time.Duration `yaml:"idle"`
+		Shutdown time.Duration `yaml:"shutdown"`
+	} `yaml:"timeouts"`
+	API struct {
+		Address      string `yaml:"address"`
+		QueryHistory *int   `yaml:"query-history"`
+		TLS          bool   `yaml:"tls"`
+		Disable      bool   `yaml:"disable"`
+	} `yaml:"api"`
+	Logging struct {
+		QueryLog string `yaml:"query-log"`
+		Format   string `yaml:"format"`
+		MaxSize  int    `yaml:"max-size"`
+		Backups  int    `yaml:"backups"`
+	} `yaml:"logging"`
+	TSIGKeys map[string]string `yaml:"tsig-keys"`
+	ACL      struct {
+		Allow []string `yaml:"allow"`
+	} `yaml:"acl"`
+	Forwarders map[string][]string `yaml:"forwarders"`
+	Resolver   struct {
+		Enabled   bool   `yaml:"enabled"`
+		RootHints string `yaml:"root-hints"`
+		Port      string `yaml:"port"`
+	} `yaml:"resolver"`
+	Zones struct {
+		Files         []string      `yaml:"files"`
+		Dirs          []string      `yaml:"dirs"`
+		Watch         bool          `yaml:"watch"`
+		WatchInterval time.Duration `yaml:"watch-interval"`
+		StateDir      string        `yaml:"state-dir"`
+	} `yaml:"zones"`
+}
+
+// values returns the settings in the file keyed by the name of the flag they
+// correspond to, settings that aren't in the file are left out
+func (fc *fileConfig) values() map[string]interface{} {
+	v := make(map[string]interface{})
+	set := func(name string, value interface{}, present bool) {
+		if present {
+			v[name] = value
+		}
+	}
+	set("dns-name", fc.DNS.Name, fc.DNS.Name != "")
+	set("dns-address", fc.DNS.Address, fc.DNS.Address != "")
+	set("dns-port", fc.DNS.Port, fc.DNS.Port != "")
+	set("dns-network", fc.DNS.Network, fc.DNS.Network != "")
+	set("dns-compression", fc.DNS.Compression, fc.DNS.Compression)
+	listeners := []string{}
+	for _, l := range fc.DNS.Listeners {
+		spec := l.Address
+		if len(l.Networks) > 0 {
+			spec = strings.Join(l.Networks, ",") + "/" + spec
+		}
+		if l.View != "" {
+			spec += "=" + l.View
+		}
+		listeners = append(listeners, spec)
+	}
+	set("listen", listeners, len(listeners) > 0)
+	set("tls-cert", fc.TLS.Cert, fc.TLS.Cert != "")
+	set("tls-key", fc.TLS.Key, fc.TLS.Key != "")
+	set("tls-self-signed", fc.TLS.SelfSigned, fc.TLS.SelfSigned)
+	set("read-timeout", fc.Timeouts.Read, fc.Timeouts.Read != 0)
+	set("write-timeout", fc.Timeouts.Write, fc.Timeouts.Write != 0)
+	set("idle-timeout", fc.Timeouts.Idle, fc.Timeouts.Idle != 0)
+	set("shutdown-timeout", fc.Timeouts.Shutdown, fc.Timeouts.Shutdown != 0)
+	set("api-uri", fc.API.Address, fc.API.Address != "")
+	set("api-tls", fc.API.TLS, fc.API.TLS)
+	set("disable-api", fc.API.Disable, fc.API.Disable)
+	if fc.API.QueryHistory != nil {
+		v["query-history"] = *fc.API.QueryHistory
+	}
+	set("query-log", fc.Logging.QueryLog, fc.Logging.QueryLog != "")
+	set("query-log-format", fc.Logging.Format, fc.Logging.Format != "")
+	set("query-log-max-size", fc.Logging.MaxSize, fc.Logging.MaxSize != 0)
+	set("query-log-backups", fc.Logging.Backups, fc.Logging.Backups != 0)
+	keys := []string{}
+	for name, secret := range fc.TSIGKeys {
+		keys = append(keys, fmt.Sprintf("%s:%s", name, secret))
+	}
+	set("tsig-key", keys, len(keys) > 0)
+	set("allow-client", fc.ACL.Allow, len(fc.ACL.Allow) > 0)
+	forwarders := []string{}
+	for suffix, upstreams := range fc.Forwarders {
+		for _, u := range upstreams {
+			forwarders = append(forwarders, fmt.Sprintf("%s=%s", suffix, u))
+		}
+	}
+	set("forward", forwarders, len(forwarders) > 0)
+	set("resolve", fc.Resolver.Enabled, fc.Resolver.Enabled)
+	set("root-hints", fc.Resolver.RootHints, fc.Resolver.RootHints != "")
+	set("ns-port", fc.Resolver.Port, fc.Resolver.Port != "")
+	set("zone-file", fc.Zones.Files, len(fc.Zones.Files) > 0)
+	set("zone-dir", fc.Zones.Dirs, len(fc.Zones.Dirs) > 0)
+	set("watch", fc.Zones.Watch, fc.Zones.Watch)
+	set("watch-interval", fc.Zones.WatchInterval, fc.Zones.WatchInterval != 0)
+	set("state-dir", fc.Zones.StateDir, fc.Zones.StateDir != "")
+	return v
+}
+
+// envVar returns the environment variable that can be used instead of the
+// flag name
+func envVar(name string) string {
+	return "DNS_WORKBENCH_" + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// options looks up settings for the run command, values from the command line
+// take precedence over environment variables which take precedence over the
+// config file
+type options struct {
+	c    *cli.Context
+	file map[string]interface{}
+}
+
+func newOptions(c *cli.Context) (*options, error) {
+	o := &options{c: c, file: make(map[string]interface{})}
+	path := c.String("config")
+	if path == "" {
+		return o, nil
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fc := &fileConfig{}
+	err = yaml.Unmarshal(content, fc)
+	if err != nil {
+		return nil, err
+	}
+	o.file = fc.values()
+	return o, nil
+}
+
+// fromFile returns the config file value for name if it wasn't overridden
+// on the command line or through the environment
+func (o *options) fromFile(name string) (interface{}, bool) {
+	if o.c.IsSet(name) || os.Getenv(envVar(name)) != "" {
+		return nil, false
+	}
+	v, present := o.file[name]
+	return v, present
+}
+
+func (o *options) String(name string) string {
+	if v, present := o.fromFile(name); present {
+		return v.(string)
+	}
+	return o.c.String(name)
+}
+
+func (o *options) StringSlice(name string) []string {
+	if v, present := o.fromFile(name); present {
+		return v.([]string)
+	}
+	return o.c.StringSlice(name)
+}
+
+func (o *options) Int(name string) int {
+	if v, present := o.fromFile(name); present {
+		return v.(int)
+	}
+	return o.c.Int(name)
+}
+
+func (o *options) Bool(name string) bool {
+	if v, present := o.fromFile(name); present {
+		return v.(bool)
+	}
+	return o.c.Bool(name)
+}
+
+func (o *options) Duration(name string) time.Duration {
+	if v, present := o.fromFile(name); present {
+		return v.(time.Duration)
+	}
+	return o.c.Duration(name)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/github.com/codegangsta/cli"
+)
+
+const testConfig = `
+dns:
+  port: "5300"
+  listeners:
+    - address: 127.0.0.1:53
+      networks: [udp]
+      view: internal
+timeouts:
+  read: 5s
+api:
+  query-history: 0
+  disable: true
+`
+
+// runSettings holds a setting of each type, read using options
+type runSettings struct {
+	port       string
+	listen     []string
+	timeout    time.Duration
+	history    int
+	disableAPI bool
+}
+
+func settings(o *options) runSettings {
+	return runSettings{
+		port:       o.String("dns-port"),
+		listen:     o.StringSlice("listen"),
+		timeout:    o.Duration("read-timeout"),
+		history:    o.Int("query-history"),
+		disableAPI: o.Bool("disable-api"),
+	}
+}
+
+// parseSettings parses args, and the environment in env, using flags like those
+// of the run command and returns the resulting settings
+func parseSettings(t *testing.T, args []string, env map[string]string) runSettings {
+	t.Helper()
+	for name, value := range env {
+		os.Setenv(name, value)
+		defer os.Unsetenv(name)
+	}
+
+	var got runSettings
+	var err error
+	app := cli.NewApp()
+	app.Commands = []cli.Command{
+		{
+			Name: "run",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "config", EnvVar: envVar("config")},
+				cli.StringFlag{Name: "dns-port", Value: "53", EnvVar: envVar("dns-port")},
+				cli.StringSliceFlag{Name: "listen", Value: &cli.StringSlice{}, EnvVar: envVar("listen")},
+				cli.DurationFlag{Name: "read-timeout", Value: 2 * time.Second, EnvVar: envVar("read-timeout")},
+				cli.IntFlag{Name: "query-history", Value: 1000, EnvVar: envVar("query-history")},
+				cli.BoolFlag{Name: "disable-api", EnvVar: envVar("disable-api")},
+			},
+			Action: func(c *cli.Context) {
+				var o *options
+				o, err = newOptions(c)
+				if err == nil {
+					got = settings(o)
+				}
+			},
+		},
+	}
+	runErr := app.Run(append([]string{"dns-workbench", "run"}, args...))
+	if runErr != nil {
+		t.Fatalf("Failed to parse arguments: %s", runErr)
+	}
+	if err != nil {
+		t.Fatalf("Failed to load config: %s", err)
+	}
+	return got
+}
+
+func TestOptionsPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yml")
+	err = ioutil.WriteFile(path, []byte(testConfig), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write config: %s", err)
+	}
+
+	defaults := runSettings{port: "53", listen: []string{}, timeout: 2 * time.Second, history: 1000}
+	fromFile := runSettings{port: "5300", listen: []string{"udp/127.0.0.1:53=internal"}, timeout: 5 * time.Second, history: 0, disableAPI: true}
+	for _, tc := range []struct {
+		desc string
+		args []string
+		env  map[string]string
+		want runSettings
+	}{
+		{
+			desc: "defaults",
+			want: defaults,
+		},
+		{
+			desc: "config file",
+			args: []string{"--config", path},
+			want: fromFile,
+		},
+		{
+			desc: "config file from the environment",
+			env:  map[string]string{"DNS_WORKBENCH_CONFIG": path},
+			want: fromFile,
+		},
+		{
+			desc: "environment overrides config file",
+			args: []string{"--config", path},
+			env: map[string]string{
+				"DNS_WORKBENCH_DNS_PORT":      "5301",
+				"DNS_WORKBENCH_LISTEN":        "127.0.0.2:53,127.0.0.3:53",
+				"DNS_WORKBENCH_READ_TIMEOUT":  "6s",
+				"DNS_WORKBENCH_QUERY_HISTORY": "10",
+			},
+			want: runSettings{port: "5301", listen: []string{"127.0.0.2:53", "127.0.0.3:53"}, timeout: 6 * time.Second, history: 10, disableAPI: true},
+		},
+		{
+			desc: "flags override environment and config file",
+			args: []string{"--config", path, "--dns-port", "5302", "--listen", "127.0.0.4:53", "--read-timeout", "7s", "--query-history", "20"},
+			env: map[string]string{
+				"DNS_WORKBENCH_DNS_PORT":      "5301",
+				"DNS_WORKBENCH_QUERY_HISTORY": "10",
+			},
+			want: runSettings{port: "5302", listen: []string{"127.0.0.4:53"}, timeout: 7 * time.Second, history: 20, disableAPI: true},
+		},
+		{
+			desc: "disable-api from the environment",
+			env:  map[string]string{"DNS_WORKBENCH_DISABLE_API": "true"},
+			want: runSettings{port: "53", listen: []string{}, timeout: 2 * time.Second, history: 1000, disableAPI: true},
+		},
+		{
+			desc: "disable-api flag",
+			args: []string{"--disable-api"},
+			want: runSettings{port: "53", listen: []string{}, timeout: 2 * time.Second, history: 1000, disableAPI: true},
+		},
+	} {
+		got := parseSettings(t, tc.args, tc.env)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %+v, expected %+v", tc.desc, got, tc.want)
+		}
+	}
+}
+
+func TestOptionsBadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yml")
+	err = ioutil.WriteFile(path, []byte("dns: [\n"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write config: %s", err)
+	}
+
+	for _, config := range []string{path, filepath.Join(dir, "missing.yml")} {
+		app := cli.NewApp()
+		var loadErr error
+		app.Commands = []cli.Command{{
+			Name:   "run",
+			Flags:  []cli.Flag{cli.StringFlag{Name: "config"}},
+			Action: func(c *cli.Context) { _, loadErr = newOptions(c) },
+		}}
+		app.Run([]string{"dns-workbench", "run", "--config", config})
+		if loadErr == nil {
+			t.Errorf("%s: loaded without an error", config)
+		}
+	}
+}
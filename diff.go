@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/rolandshoemaker/dns-workbench/workbench"
+)
+
+// loadPath loads the zone file at path, or every zone file in it if it is a
+// directory
+func loadPath(path string) (workbench.RawZones, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return workbench.RawZones{}, err
+	}
+	if info.IsDir() {
+		return workbench.LoadFiles(nil, []string{path})
+	}
+	return workbench.LoadFiles([]string{path}, nil)
+}
+
+// postReload sends rz to the reload API of the workbench at apiURI, with
+// dryRun the changes are returned instead of applied
+func postReload(apiURI, mode string, rz workbench.RawZones, ifMatch string, dryRun bool) (*http.Response, error) {
+	rzJSON, err := json.Marshal(rz)
+	if err != nil {
+		return nil, err
+	}
+	uri := fmt.Sprintf("http://%s/api/reload?mode=%s", apiURI, url.QueryEscape(mode))
+	if dryRun {
+		uri += "&dry_run=true"
+	}
+	req, err := http.NewRequest("POST", uri, bytes.NewBuffer(rzJSON))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if ifMatch != "" {
+		if !strings.HasPrefix(ifMatch, "\"") && ifMatch != "*" {
+			ifMatch = fmt.Sprintf("\"%s\"", ifMatch)
+		}
+		req.Header.Set("If-Match", ifMatch)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		defer resp.Body.Close()
+		apiErr, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%s", apiErr)
+	}
+	return resp, nil
+}
+
+// previewReload returns the changes reloading rz into the workbench at apiURI
+// would make, along with the ETag of the zones they were computed against
+func previewReload(apiURI, mode string, rz workbench.RawZones, ifMatch string) ([]workbench.RRsetChange, string, error) {
+	resp, err := postReload(apiURI, mode, rz, ifMatch, true)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	changes := []workbench.RRsetChange{}
+	err = json.NewDecoder(resp.Body).Decode(&changes)
+	if err != nil {
+		return nil, "", err
+	}
+	return changes, resp.Header.Get("ETag"), nil
+}
+
+// printDiff writes changes grouped by zone, values only in the old RRset are
+// prefixed with - and values only in the new one with +
+func printDiff(out io.Writer, changes []workbench.RRsetChange) {
+	last := ""
+	added, removed, changed := 0, 0, 0
+	for _, c := range changes {
+		header := c.Zone
+		if c.View != "" {
+			header += fmt.Sprintf(" (view %s)", c.View)
+		}
+		if header != last {
+			if last != "" {
+				fmt.Fprintln(out)
+			}
+			fmt.Fprintf(out, "%s\n", header)
+			last = header
+		}
+		for _, v := range c.Old {
+			prefix := "-"
+			if containsValue(c.New, v) {
+				prefix = " "
+			}
+			fmt.Fprintf(out, "%s %s %s %s\n", prefix, c.Name, c.Type, v)
+		}
+		for _, v := range c.New {
+			if !containsValue(c.Old, v) {
+				fmt.Fprintf(out, "+ %s %s %s\n", c.Name, c.Type, v)
+			}
+		}
+		switch c.Change {
+		case workbench.ChangeAdded:
+			added++
+		case workbench.ChangeRemoved:
+			removed++
+		case workbench.ChangeChanged:
+			changed++
+		}
+	}
+	if len(changes) > 0 {
+		fmt.Fprintln(out)
+	}
+	fmt.Fprintf(out, "%d RRsets added, %d removed, %d changed\n", added, removed, changed)
+}
+
+func containsValue(values []string, v string) bool {
+	for _, other := range values {
+		if other == v {
+			return true
+		}
+	}
+	return false
+}
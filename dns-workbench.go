@@ -1,7 +1,8 @@
 package main
 
 import (
-	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -9,200 +10,96 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
 
 	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/github.com/codegangsta/cli"
 	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/github.com/miekg/dns"
 	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/gopkg.in/yaml.v2"
+	"github.com/rolandshoemaker/dns-workbench/workbench"
 )
 
-type rawZones struct {
-	// so horribly gross but w/e for now
-	Zones map[string]map[string]map[string][]string `yaml:"zones"`
-}
-
-type zones map[string]map[uint16][]dns.RR
-type auth map[string]*dns.RR
-
-func constrcutZones(rz rawZones, serverName string) (zones, auth, error) {
-	a := make(auth)
-	z := make(zones)
-	for zoneName, hosts := range rz.Zones {
-		zoneName = dns.Fqdn(zoneName)
-		soa, err := dns.NewRR(fmt.Sprintf("%s SOA %s dns.%s  %s 10000 2400 604800 3600", zoneName, serverName, serverName, time.Now().Format("0601021504")))
-		if err != nil {
-			return nil, nil, err
-		}
-		z[zoneName] = map[uint16][]dns.RR{
-			dns.TypeSOA: []dns.RR{soa},
+// pathsSignature describes the modification time and size of every file in
+// paths, and of every file inside the directories in dirs
+func pathsSignature(paths []string, dirs []string) string {
+	sig := ""
+	add := func(path string) {
+		if info, err := os.Stat(path); err == nil {
+			sig += fmt.Sprintf("%s %s %d\n", path, info.ModTime(), info.Size())
 		}
-
-		authRR, err := dns.NewRR(fmt.Sprintf("%s NS %s", zoneName, serverName))
+	}
+	for _, path := range paths {
+		add(path)
+	}
+	for _, dir := range dirs {
+		entries, err := ioutil.ReadDir(dir)
 		if err != nil {
-			return nil, nil, fmt.Errorf("Failed to create authority NS record: %v", err)
+			continue
 		}
-
-		for host, records := range hosts {
-			host = dns.Fqdn(host)
-			if _, present := z[host]; !present {
-				z[host] = make(map[uint16][]dns.RR)
-			}
-
-			for typeStr, v := range records {
-				rType, present := dns.StringToType[strings.ToUpper(typeStr)]
-				if !present {
-					return nil, nil, fmt.Errorf("Invalid record type")
-				}
-
-				for _, presentation := range v {
-					rr, err := dns.NewRR(fmt.Sprintf("%s %s %s", host, strings.ToUpper(typeStr), presentation))
-					if err != nil {
-						return nil, nil, fmt.Errorf("Couldn't parse record: %v", err)
-					}
-					z[host][rType] = append(z[host][rType], rr)
-					a[host] = &authRR
-				}
-			}
+		for _, e := range entries {
+			add(filepath.Join(dir, e.Name()))
 		}
 	}
-	return z, a, nil
-}
-
-type workbench struct {
-	mu sync.RWMutex
-	z  zones
-	a  auth
-
-	l *log.Logger
-
-	name        string
-	bind        string
-	port        string
-	net         string
-	rTimeout    time.Duration
-	wTimeout    time.Duration
-	iTimeout    time.Duration
-	compression bool
+	return sig
 }
 
-func (wb *workbench) reloadZones(nz zones, na auth) error {
-	wb.mu.Lock()
-	defer wb.mu.Unlock()
-	wb.z = nz
-	wb.a = na
-	return nil
-}
-
-func (wb *workbench) dnsHandler(w dns.ResponseWriter, r *dns.Msg) {
-	wb.mu.RLock()
-	defer wb.mu.RUnlock()
-	defer w.Close()
-	m := new(dns.Msg)
-	m.SetReply(r)
-	m.Compress = wb.compression
-
-	if len(r.Question) > 1 || r.Rcode != dns.OpcodeQuery {
-		m.Rcode = dns.RcodeNotImplemented
-	} else if len(r.Question) == 0 {
-		m.Rcode = dns.RcodeFormatError
-	}
-	if m.Rcode == dns.RcodeFormatError || m.Rcode == dns.RcodeNotImplemented {
-		w.WriteMsg(m)
-		return
-	}
-
-	q := &r.Question[0]
-
-	wb.l.Printf("Received query for [%s] %s\n", dns.TypeToString[q.Qtype], q.Name)
-	allRecords, present := wb.z[q.Name]
-	if !present {
-		m.Rcode = dns.RcodeNameError
-		w.WriteMsg(m)
-		return
-	}
-
-	if auth, present := wb.a[q.Name]; present {
-		m.Authoritative = true
-		m.Ns = append(m.Ns, *auth)
-	}
-
-	qRecords, present := allRecords[q.Qtype]
-	if !present {
-		m.Rcode = dns.RcodeNXRrset
-		w.WriteMsg(m)
-		return
-	}
-
-	m.Answer = append(m.Answer, qRecords...)
-	w.WriteMsg(m)
-	return
-}
-
-func (wb *workbench) serveWorkbench() error {
-	dns.HandleFunc(".", wb.dnsHandler)
-	server := &dns.Server{
-		Addr:         net.JoinHostPort(wb.bind, wb.port),
-		Net:          wb.net,
-		ReadTimeout:  wb.rTimeout,
-		WriteTimeout: wb.wTimeout,
-		IdleTimeout:  func() time.Duration { return wb.iTimeout },
-		NotifyStartedFunc: func() {
-			wb.mu.RLock()
-			defer wb.mu.RUnlock()
-			wb.l.Printf("DNS listening on %s:%s, serving %d zones\n", wb.bind, wb.port, len(wb.z))
-		},
+// watchPaths polls paths and dirs every interval and sends on changed
+// whenever a file is modified, added or removed
+func watchPaths(paths []string, dirs []string, interval time.Duration, changed chan<- struct{}) {
+	last := pathsSignature(paths, dirs)
+	for range time.Tick(interval) {
+		sig := pathsSignature(paths, dirs)
+		if sig == last {
+			continue
+		}
+		last = sig
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
 	}
-	err := server.ListenAndServe()
-	return err
-}
-
-func sendError(err string, w http.ResponseWriter) {
-	w.WriteHeader(400)
-	w.Write([]byte(err))
 }
 
-func (wb *workbench) apiReload(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		sendError("Method not supported", w)
-		return
+// parseListener parses a listener in the form [networks/]address[=view] where
+// networks is a comma separated list, e.g. udp,tcp6/[::1]:53=internal. The
+// port can be left out for tls only listeners and defaults to 853.
+func parseListener(spec string) (workbench.Listener, error) {
+	l := workbench.Listener{}
+	if i := strings.LastIndex(spec, "="); i != -1 {
+		spec, l.View = spec[:i], spec[i+1:]
 	}
-
-	rStart := time.Now()
-	var rz rawZones
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		sendError(err.Error(), w)
-		return
+	if i := strings.Index(spec, "/"); i != -1 {
+		l.Nets = strings.Split(spec[:i], ",")
+		spec = spec[i+1:]
 	}
-	err = json.Unmarshal(body, &rz)
-	if err != nil {
-		sendError(err.Error(), w)
-		return
+	if len(l.Nets) == 1 && l.Nets[0] == "tls" {
+		if _, _, err := net.SplitHostPort(spec); err != nil {
+			spec = net.JoinHostPort(strings.Trim(spec, "[]"), "853")
+		}
 	}
-
-	z, a, err := constrcutZones(rz, wb.name)
+	_, _, err := net.SplitHostPort(spec)
 	if err != nil {
-		sendError(err.Error(), w)
-		return
+		return l, err
 	}
-
-	wb.reloadZones(z, a)
-
-	wb.mu.RLock()
-	defer wb.mu.RUnlock()
-	wb.l.Printf("Reloaded zone definitions, now serving %d zones, took %s\n", len(wb.z), time.Since(rStart))
+	l.Addr = spec
+	return l, nil
 }
 
-func loadYAML(filename string) (rawZones, error) {
-	rz := rawZones{}
-	content, err := ioutil.ReadFile(filename)
-	if err != nil {
-		return rz, nil
+// newResolver creates a resolver using the root servers in the root hints
+// file at hints, or the real root servers if it is empty
+func newResolver(hints, port string) (*workbench.Resolver, error) {
+	roots := workbench.DefaultRoots
+	if hints != "" {
+		var err error
+		roots, err = workbench.LoadRootHints(hints)
+		if err != nil {
+			return nil, err
+		}
 	}
-	err = yaml.Unmarshal(content, &rz)
-	return rz, err
+	return workbench.NewResolver(roots, port), nil
 }
 
 func main() {
@@ -219,141 +116,815 @@ func main() {
 			Usage: "Starts the DNS server",
 			Flags: []cli.Flag{
 				cli.StringFlag{
-					Name:  "dns-name",
-					Value: "localhost",
-					Usage: "Hostname of the DNS server",
+					Name:   "config",
+					Usage:  "Path to a YAML config file, flags and environment variables override its settings",
+					EnvVar: envVar("config"),
 				},
 				cli.StringFlag{
-					Name:  "dns-address",
-					Value: "127.0.0.1",
-					Usage: "Address for the DNS server to listen on",
+					Name:   "dns-name",
+					Value:  "localhost",
+					Usage:  "Hostname of the DNS server",
+					EnvVar: envVar("dns-name"),
 				},
 				cli.StringFlag{
-					Name:  "dns-port",
-					Value: "8053",
-					Usage: "Port for the DNS server to listen on",
+					Name:   "dns-address",
+					Value:  "127.0.0.1",
+					Usage:  "Address for the DNS server to listen on",
+					EnvVar: envVar("dns-address"),
 				},
 				cli.StringFlag{
-					Name:  "dns-network",
-					Value: "udp",
-					Usage: "Network for the DNS server to listen on",
+					Name:   "dns-port",
+					Value:  "8053",
+					Usage:  "Port for the DNS server to listen on",
+					EnvVar: envVar("dns-port"),
+				},
+				cli.StringFlag{
+					Name:   "dns-network",
+					Value:  "udp",
+					Usage:  "Network for the DNS server to listen on",
+					EnvVar: envVar("dns-network"),
+				},
+				cli.StringSliceFlag{
+					Name:   "listen",
+					Value:  &cli.StringSlice{},
+					Usage:  "Address to listen on in the form [networks/]address[=view], replaces --dns-address, --dns-port and --dns-network, can be repeated",
+					EnvVar: envVar("listen"),
+				},
+				cli.StringFlag{
+					Name:   "tls-cert",
+					Usage:  "Path to the PEM certificate used by tls listeners",
+					EnvVar: envVar("tls-cert"),
+				},
+				cli.StringFlag{
+					Name:   "tls-key",
+					Usage:  "Path to the PEM private key used by tls listeners",
+					EnvVar: envVar("tls-key"),
 				},
 				cli.BoolFlag{
-					Name:  "dns-compression",
-					Usage: "Use DNS message compression",
+					Name:   "tls-self-signed",
+					Usage:  "Generate a self-signed certificate for tls listeners",
+					EnvVar: envVar("tls-self-signed"),
+				},
+				cli.BoolFlag{
+					Name:   "dns-compression",
+					Usage:  "Use DNS message compression",
+					EnvVar: envVar("dns-compression"),
+				},
+				cli.DurationFlag{
+					Name:   "read-timeout",
+					Value:  time.Second * 2,
+					Usage:  "How long to wait for a query to be read",
+					EnvVar: envVar("read-timeout"),
+				},
+				cli.DurationFlag{
+					Name:   "write-timeout",
+					Value:  time.Second * 2,
+					Usage:  "How long to wait for a response to be written",
+					EnvVar: envVar("write-timeout"),
+				},
+				cli.DurationFlag{
+					Name:   "idle-timeout",
+					Value:  time.Second * 8,
+					Usage:  "How long to keep idle TCP connections open",
+					EnvVar: envVar("idle-timeout"),
+				},
+				cli.StringSliceFlag{
+					Name:   "allow-client",
+					Value:  &cli.StringSlice{},
+					Usage:  "Network allowed to query the DNS server, other clients are refused, can be repeated",
+					EnvVar: envVar("allow-client"),
+				},
+				cli.StringSliceFlag{
+					Name:   "forward",
+					Value:  &cli.StringSlice{},
+					Usage:  "Upstream resolver for names without any records in the form [suffix=]address, can be repeated",
+					EnvVar: envVar("forward"),
+				},
+				cli.BoolFlag{
+					Name:   "resolve",
+					Usage:  "Resolve names without any records that no forwarder covers iteratively, for queries with the RD bit set",
+					EnvVar: envVar("resolve"),
 				},
 				cli.StringFlag{
-					Name:  "zone-file",
-					Usage: "Path to workbench zones file",
+					Name:   "root-hints",
+					Usage:  "Path to a root hints file used when resolving, defaults to the real root servers",
+					EnvVar: envVar("root-hints"),
 				},
 				cli.StringFlag{
-					Name:  "api-uri",
-					Value: "127.0.0.1:5353",
-					Usage: "Address for the HTTP API to listen on",
+					Name:   "ns-port",
+					Value:  "53",
+					Usage:  "Port nameservers are queried on when resolving",
+					EnvVar: envVar("ns-port"),
+				},
+				cli.StringSliceFlag{
+					Name:   "zone-file",
+					Value:  &cli.StringSlice{},
+					Usage:  "Path to workbench zones file, can be repeated",
+					EnvVar: envVar("zone-file"),
+				},
+				cli.StringSliceFlag{
+					Name:   "zone-dir",
+					Value:  &cli.StringSlice{},
+					Usage:  "Directory containing workbench zone files, can be repeated",
+					EnvVar: envVar("zone-dir"),
 				},
-				cli.BoolTFlag{
-					Name:  "disable-api",
-					Usage: "Don't start the HTTP API",
+				cli.StringSliceFlag{
+					Name:   "tsig-key",
+					Value:  &cli.StringSlice{},
+					Usage:  "TSIG key accepted by the DNS server in the form name:base64-secret",
+					EnvVar: envVar("tsig-key"),
+				},
+				cli.StringFlag{
+					Name:   "query-log",
+					Value:  "stdout",
+					Usage:  "Where to write the query log, either 'stdout', 'none' or a file path",
+					EnvVar: envVar("query-log"),
+				},
+				cli.StringFlag{
+					Name:   "query-log-format",
+					Value:  "text",
+					Usage:  "Format of the query log, either 'text' or 'json'",
+					EnvVar: envVar("query-log-format"),
+				},
+				cli.IntFlag{
+					Name:   "query-log-max-size",
+					Usage:  "Rotate the query log file once it is larger than this many megabytes, 0 disables rotation",
+					EnvVar: envVar("query-log-max-size"),
+				},
+				cli.IntFlag{
+					Name:   "query-log-backups",
+					Value:  5,
+					Usage:  "Number of rotated query log files to keep",
+					EnvVar: envVar("query-log-backups"),
+				},
+				cli.IntFlag{
+					Name:   "query-history",
+					Value:  1000,
+					Usage:  "Number of queries to keep for the query history API, 0 disables it",
+					EnvVar: envVar("query-history"),
+				},
+				cli.StringFlag{
+					Name:   "api-uri",
+					Value:  "127.0.0.1:5353",
+					Usage:  "Address for the HTTP API to listen on",
+					EnvVar: envVar("api-uri"),
+				},
+				cli.BoolFlag{
+					Name:   "api-tls",
+					Usage:  "Serve the HTTP API over HTTPS using the TLS certificate, DNS over HTTPS clients generally require it",
+					EnvVar: envVar("api-tls"),
+				},
+				cli.BoolFlag{
+					Name:   "disable-api",
+					Usage:  "Don't start the HTTP API, the workbench can then only be reloaded and shut down using signals",
+					EnvVar: envVar("disable-api"),
+				},
+				cli.BoolFlag{
+					Name:   "watch",
+					Usage:  "Reload the zone file whenever it changes",
+					EnvVar: envVar("watch"),
+				},
+				cli.DurationFlag{
+					Name:   "watch-interval",
+					Value:  time.Second,
+					Usage:  "How often to check the zone file for changes",
+					EnvVar: envVar("watch-interval"),
+				},
+				cli.DurationFlag{
+					Name:   "shutdown-timeout",
+					Value:  time.Second * 10,
+					Usage:  "How long to wait for in-flight queries and API requests when shutting down",
+					EnvVar: envVar("shutdown-timeout"),
+				},
+				cli.StringFlag{
+					Name:   "state-dir",
+					Usage:  "Directory to save zones and script state to so they survive restarts",
+					EnvVar: envVar("state-dir"),
 				},
 			},
 			Action: func(c *cli.Context) {
-				var rz rawZones
-				var z zones
-				var a auth
+				var rz workbench.RawZones
 				var err error
 
 				logger := log.New(os.Stdout, "[dns-wb] ", log.Flags())
 
-				if rzFile := c.String("zone-file"); rzFile != "" {
-					rz, err = loadYAML(rzFile)
+				o, err := newOptions(c)
+				if err != nil {
+					logger.Fatalf("Failed to load config file: %s\n", err)
+				}
+
+				zoneFiles, zoneDirs := o.StringSlice("zone-file"), o.StringSlice("zone-dir")
+				if len(zoneFiles) > 0 || len(zoneDirs) > 0 {
+					rz, err = workbench.LoadFiles(zoneFiles, zoneDirs)
 					if err != nil {
-						logger.Fatalf("Failed to read zone file: %s\n", err)
+						logger.Fatalf("Failed to read zone files: %s\n", err)
 					}
-					z, a, err = constrcutZones(rz, dns.Fqdn(c.String("dns-name")))
+				}
+
+				tsig := make(map[string]string)
+				for _, k := range o.StringSlice("tsig-key") {
+					fields := strings.SplitN(k, ":", 2)
+					if len(fields) != 2 {
+						logger.Fatalf("Invalid TSIG key: %s\n", k)
+					}
+					tsig[fields[0]] = fields[1]
+				}
+
+				forwarders := make(map[string][]string)
+				for _, spec := range o.StringSlice("forward") {
+					suffix, upstream := ".", spec
+					if i := strings.Index(spec, "="); i != -1 {
+						suffix, upstream = spec[:i], spec[i+1:]
+					}
+					forwarders[suffix] = append(forwarders[suffix], upstream)
+				}
+
+				var resolver *workbench.Resolver
+				if o.Bool("resolve") {
+					resolver, err = newResolver(o.String("root-hints"), o.String("ns-port"))
 					if err != nil {
-						logger.Fatalf("Failed to parse zone file: %s\n", err)
+						logger.Fatalf("Failed to load root hints: %s\n", err)
 					}
-				} else {
-					z = make(zones)
-					a = make(auth)
-				}
-
-				wb := workbench{
-					z:           z,
-					a:           a,
-					l:           logger,
-					name:        dns.Fqdn(c.String("dns-name")),
-					bind:        c.String("dns-address"),
-					port:        c.String("dns-port"),
-					net:         c.String("dns-network"),
-					rTimeout:    time.Second * 2,
-					wTimeout:    time.Second * 2,
-					iTimeout:    time.Second * 8,
-					compression: c.Bool("dns-compression"),
 				}
-				go func() {
-					http.HandleFunc("/api/reload", wb.apiReload)
-					logger.Printf("API listening on %s\n", c.String("api-uri"))
-					err := http.ListenAndServe(c.String("api-uri"), nil)
+
+				listeners := []workbench.Listener{}
+				for _, spec := range o.StringSlice("listen") {
+					l, err := parseListener(spec)
+					if err != nil {
+						logger.Fatalf("Invalid listener %s: %s\n", spec, err)
+					}
+					listeners = append(listeners, l)
+				}
+
+				var tlsConfig *tls.Config
+				if cert, key := o.String("tls-cert"), o.String("tls-key"); cert != "" || key != "" {
+					pair, err := tls.LoadX509KeyPair(cert, key)
 					if err != nil {
+						logger.Fatalf("Failed to load TLS certificate: %s\n", err)
+					}
+					tlsConfig = &tls.Config{Certificates: []tls.Certificate{pair}}
+				} else if o.Bool("tls-self-signed") {
+					hosts := []string{o.String("dns-name")}
+					seen := map[string]bool{o.String("dns-name"): true}
+					for _, l := range listeners {
+						if host, _, err := net.SplitHostPort(l.Addr); err == nil && !seen[host] {
+							seen[host] = true
+							hosts = append(hosts, host)
+						}
+					}
+					pair, err := workbench.SelfSignedCertificate(hosts)
+					if err != nil {
+						logger.Fatalf("Failed to generate TLS certificate: %s\n", err)
+					}
+					tlsConfig = &tls.Config{Certificates: []tls.Certificate{pair}}
+				}
+
+				config := workbench.Config{
+					Name:           o.String("dns-name"),
+					Addr:           net.JoinHostPort(o.String("dns-address"), o.String("dns-port")),
+					Net:            o.String("dns-network"),
+					Listeners:      listeners,
+					TLSConfig:      tlsConfig,
+					ReadTimeout:    o.Duration("read-timeout"),
+					WriteTimeout:   o.Duration("write-timeout"),
+					IdleTimeout:    o.Duration("idle-timeout"),
+					Compression:    o.Bool("dns-compression"),
+					TSIGKeys:       tsig,
+					AllowedClients: o.StringSlice("allow-client"),
+					Forwarders:     forwarders,
+					Resolver:       resolver,
+					Logger:         logger,
+					QueryLogFormat: o.String("query-log-format"),
+					QueryHistory:   o.Int("query-history"),
+					StateDir:       o.String("state-dir"),
+				}
+				switch dest := o.String("query-log"); dest {
+				case "none":
+				case "stdout":
+					config.QueryLog = os.Stdout
+					config.QueryLogPrefix = "[dns-wb] "
+				default:
+					f, err := workbench.OpenRotatingFile(dest, int64(o.Int("query-log-max-size"))*1024*1024, o.Int("query-log-backups"))
+					if err != nil {
+						logger.Fatalf("Failed to open query log: %s\n", err)
+					}
+					defer f.Close()
+					config.QueryLog = f
+				}
+
+				wb, err := workbench.New(rz, config)
+				if err != nil {
+					logger.Fatalf("Failed to create workbench: %s\n", err)
+				}
+				// Register for signals before anything starts listening so
+				// that an early SIGTERM still results in a clean shutdown
+				signals := make(chan os.Signal, 1)
+				signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+
+				changed := make(chan struct{}, 1)
+				if o.Bool("watch") {
+					if len(zoneFiles) == 0 && len(zoneDirs) == 0 {
+						logger.Fatalf("Zone file or directory option is required to watch for changes\n")
+					}
+					go watchPaths(zoneFiles, zoneDirs, o.Duration("watch-interval"), changed)
+				}
+				reload := func(reason string) {
+					if len(zoneFiles) == 0 && len(zoneDirs) == 0 {
+						logger.Printf("Received %s but no zone files were specified, ignoring\n", reason)
+						return
+					}
+					logger.Printf("Reloading zone files after %s\n", reason)
+					err := wb.ReloadFiles(zoneFiles, zoneDirs)
+					if err != nil {
+						logger.Printf("Failed to reload zone files, still serving previous zones: %s\n", err)
+					}
+				}
+
+				api := &http.Server{Addr: o.String("api-uri"), Handler: wb.APIHandler()}
+				if o.Bool("api-tls") {
+					if tlsConfig == nil {
+						logger.Fatalf("A TLS certificate is required to serve the API over HTTPS\n")
+					}
+					api.TLSConfig = tlsConfig
+				}
+				apiErr := make(chan error, 1)
+				if !o.Bool("disable-api") {
+					go func() {
+						logger.Printf("API listening on %s\n", o.String("api-uri"))
+						if api.TLSConfig != nil {
+							apiErr <- api.ListenAndServeTLS("", "")
+							return
+						}
+						apiErr <- api.ListenAndServe()
+					}()
+				}
+				dnsErr := make(chan error, 1)
+				go func() {
+					dnsErr <- wb.ListenAndServe()
+				}()
+
+				exitCode := 0
+			wait:
+				for {
+					select {
+					case sig := <-signals:
+						if sig == syscall.SIGHUP {
+							reload("SIGHUP")
+							continue
+						}
+						logger.Printf("Received %s, shutting down\n", sig)
+						break wait
+					case <-changed:
+						reload("a file change")
+					case <-wb.ShutdownRequested():
+						break wait
+					case err := <-apiErr:
 						logger.Printf("HTTP API crashed: %s\n", err)
-						// Dont' exit
+						exitCode = 1
+						break wait
+					case err := <-dnsErr:
+						logger.Fatalf("DNS server crashed: %s\n", err)
 					}
+				}
+
+				timeout := o.Duration("shutdown-timeout")
+				ctx, cancel := context.WithTimeout(context.Background(), timeout)
+				defer cancel()
+				apiShutdown := make(chan error, 1)
+				go func() {
+					apiShutdown <- api.Shutdown(ctx)
 				}()
-				err = wb.serveWorkbench()
+				err = wb.Shutdown(timeout)
 				if err != nil {
-					logger.Fatalf("DNS server crashed: %s\n", err)
-					os.Exit(1)
+					logger.Printf("Failed to shut down DNS server: %s\n", err)
+					exitCode = 1
 				}
+				err = <-apiShutdown
+				if err != nil {
+					logger.Printf("Failed to shut down HTTP API: %s\n", err)
+					exitCode = 1
+				}
+				logger.Printf("Shut down\n")
+				os.Exit(exitCode)
 			},
 		},
 		{
 			Name:  "reload",
 			Usage: "Loads a new zone file into a running workbench",
 			Flags: []cli.Flag{
-				cli.StringFlag{
+				cli.StringSliceFlag{
 					Name:  "zone-file",
-					Usage: "Path to workbench zones file",
+					Value: &cli.StringSlice{},
+					Usage: "Path to workbench zones file, can be repeated",
+				},
+				cli.StringSliceFlag{
+					Name:  "zone-dir",
+					Value: &cli.StringSlice{},
+					Usage: "Directory containing workbench zone files, can be repeated",
 				},
 				cli.StringFlag{
 					Name:  "api-uri",
 					Value: "127.0.0.1:5353",
 					Usage: "Address for the HTTP API",
 				},
+				cli.StringFlag{
+					Name:  "mode",
+					Value: workbench.ModeReplace,
+					Usage: "How to apply the zones, replace, merge or delete",
+				},
+				cli.StringSliceFlag{
+					Name:  "zone",
+					Value: &cli.StringSlice{},
+					Usage: "Name of a zone to delete in delete mode, can be repeated",
+				},
+				cli.StringFlag{
+					Name:  "if-match",
+					Usage: "Only apply the update if the zones still have this ETag",
+				},
+				cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "Print the changes the update would make without applying them",
+				},
 			},
 			Action: func(c *cli.Context) {
 				logger := log.New(os.Stdout, "[dns-wb] ", log.Flags())
 
-				if c.String("zone-file") == "" {
-					logger.Fatalf("Zone file option is required\n")
+				zoneFiles, zoneDirs := c.StringSlice("zone-file"), c.StringSlice("zone-dir")
+				rz := workbench.RawZones{}
+				if len(zoneFiles) > 0 || len(zoneDirs) > 0 {
+					var err error
+					rz, err = workbench.LoadFiles(zoneFiles, zoneDirs)
+					if err != nil {
+						logger.Fatalf("Failed to load zone files: %s\n", err)
+					}
+				} else if c.String("mode") != workbench.ModeDelete || len(c.StringSlice("zone")) == 0 {
+					logger.Fatalf("Zone file or directory option is required\n")
+				}
+				for _, zone := range c.StringSlice("zone") {
+					if rz.Zones == nil {
+						rz.Zones = make(map[string]map[string]map[string][]string)
+					}
+					rz.Zones[zone] = nil
+				}
+				if c.Bool("dry-run") {
+					changes, tag, err := previewReload(c.String("api-uri"), c.String("mode"), rz, c.String("if-match"))
+					if err != nil {
+						logger.Fatalf("Failed to preview reload: %s\n", err)
+					}
+					printDiff(os.Stdout, changes)
+					logger.Printf("Nothing was changed, use --if-match %s to apply exactly these changes\n", tag)
+					return
+				}
+				resp, err := postReload(c.String("api-uri"), c.String("mode"), rz, c.String("if-match"), false)
+				if err != nil {
+					logger.Fatalf("Failed to reload zones: %s\n", err)
+				}
+				resp.Body.Close()
+				logger.Printf("Succesesfully reloaded zones, ETag is now %s\n", resp.Header.Get("ETag"))
+			},
+		},
+		{
+			Name:        "diff",
+			Usage:       "Shows the RRsets that differ between two zone files, or a zone file and a running workbench",
+			Description: "Usage: dns-workbench diff [options] old new, or dns-workbench diff --live [options] new",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "live",
+					Usage: "Compare against the zones served by a running workbench",
+				},
+				cli.StringFlag{
+					Name:  "api-uri",
+					Value: "127.0.0.1:5353",
+					Usage: "Address for the HTTP API of the workbench used with --live",
+				},
+				cli.StringFlag{
+					Name:  "mode",
+					Value: workbench.ModeReplace,
+					Usage: "How the zones would be applied with --live, replace, merge or delete",
+				},
+				cli.StringFlag{
+					Name:  "format",
+					Value: "text",
+					Usage: "Output format, either text or json",
+				},
+			},
+			Action: func(c *cli.Context) {
+				logger := log.New(os.Stderr, "[dns-wb] ", log.Flags())
+
+				args := c.Args()
+				var changes []workbench.RRsetChange
+				if c.Bool("live") {
+					if len(args) != 1 {
+						logger.Fatalf("Usage: dns-workbench diff --live [options] new\n")
+					}
+					rz, err := loadPath(args[0])
+					if err != nil {
+						logger.Fatalf("Failed to load %s: %s\n", args[0], err)
+					}
+					changes, _, err = previewReload(c.String("api-uri"), c.String("mode"), rz, "")
+					if err != nil {
+						logger.Fatalf("Failed to preview reload: %s\n", err)
+					}
+				} else {
+					if len(args) != 2 {
+						logger.Fatalf("Usage: dns-workbench diff [options] old new\n")
+					}
+					old, err := loadPath(args[0])
+					if err != nil {
+						logger.Fatalf("Failed to load %s: %s\n", args[0], err)
+					}
+					updated, err := loadPath(args[1])
+					if err != nil {
+						logger.Fatalf("Failed to load %s: %s\n", args[1], err)
+					}
+					changes, err = workbench.Diff(old, updated)
+					if err != nil {
+						logger.Fatalf("Failed to compare zones: %s\n", err)
+					}
+				}
+
+				switch c.String("format") {
+				case "text":
+					printDiff(os.Stdout, changes)
+				case "json":
+					content, err := json.MarshalIndent(changes, "", "  ")
+					if err != nil {
+						logger.Fatalf("Failed to marshal changes: %s\n", err)
+					}
+					fmt.Printf("%s\n", content)
+				default:
+					logger.Fatalf("Invalid output format %s\n", c.String("format"))
 				}
-				rz, err := loadYAML(c.String("zone-file"))
+				// like diff(1), exit with 1 when there are differences
+				if len(changes) > 0 {
+					os.Exit(1)
+				}
+			},
+		},
+		{
+			Name:  "hierarchy",
+			Usage: "Generates root and TLD zones delegating to a set of zones",
+			Flags: []cli.Flag{
+				cli.StringSliceFlag{
+					Name:  "zone-file",
+					Value: &cli.StringSlice{},
+					Usage: "Path to workbench zones file, can be repeated",
+				},
+				cli.StringSliceFlag{
+					Name:  "zone-dir",
+					Value: &cli.StringSlice{},
+					Usage: "Directory containing workbench zone files, can be repeated",
+				},
+				cli.StringSliceFlag{
+					Name:  "address",
+					Value: &cli.StringSlice{},
+					Usage: "Address of the workbench used for every nameserver, can be repeated (default: 127.0.0.1)",
+				},
+				cli.BoolFlag{
+					Name:  "ds",
+					Usage: "Add DS records for zones with DNSKEY records",
+				},
+				cli.StringFlag{
+					Name:  "output",
+					Usage: "Path to write the generated zone file to, defaults to stdout",
+				},
+				cli.StringFlag{
+					Name:  "root-hints",
+					Usage: "Path to write a root hints file to",
+				},
+			},
+			Action: func(c *cli.Context) {
+				logger := log.New(os.Stderr, "[dns-wb] ", log.Flags())
+
+				zoneFiles, zoneDirs := c.StringSlice("zone-file"), c.StringSlice("zone-dir")
+				if len(zoneFiles) == 0 && len(zoneDirs) == 0 {
+					logger.Fatalf("Zone file or directory option is required\n")
+				}
+				rz, err := workbench.LoadFiles(zoneFiles, zoneDirs)
 				if err != nil {
-					logger.Fatalf("Failed to load zone file: %s\n", err)
+					logger.Fatalf("Failed to load zone files: %s\n", err)
+				}
+				addrs := c.StringSlice("address")
+				if len(addrs) == 0 {
+					addrs = []string{"127.0.0.1"}
 				}
-				rzJSON, err := json.Marshal(rz)
+
+				rz, err = workbench.GenerateHierarchy(rz, addrs, c.Bool("ds"))
 				if err != nil {
-					logger.Fatalf("Failed to marshal zone file to JSON: %s\n", err)
+					logger.Fatalf("Failed to generate hierarchy: %s\n", err)
 				}
-				req, err := http.NewRequest("POST", fmt.Sprintf("http://%s/api/reload", c.String("api-uri")), bytes.NewBuffer(rzJSON))
-				req.Header.Set("Content-Type", "application/json")
-				client := &http.Client{}
-				resp, err := client.Do(req)
+				content, err := yaml.Marshal(rz)
 				if err != nil {
-					logger.Fatalf("Failed to send update: %s\n", err)
+					logger.Fatalf("Failed to marshal zones: %s\n", err)
+				}
+				if path := c.String("output"); path != "" {
+					err = ioutil.WriteFile(path, content, 0644)
+					if err != nil {
+						logger.Fatalf("Failed to write zone file: %s\n", err)
+					}
+				} else {
+					os.Stdout.Write(content)
 				}
-				defer resp.Body.Close()
 
-				if resp.StatusCode != 200 {
-					apiErr, err := ioutil.ReadAll(resp.Body)
+				if path := c.String("root-hints"); path != "" {
+					hints, err := workbench.RootHints(addrs)
 					if err != nil {
-						logger.Fatalf("Failed to read response body: %s\n", err)
-						return
+						logger.Fatalf("Failed to generate root hints: %s\n", err)
+					}
+					err = ioutil.WriteFile(path, []byte(hints), 0644)
+					if err != nil {
+						logger.Fatalf("Failed to write root hints: %s\n", err)
+					}
+				}
+			},
+		},
+		{
+			Name:        "query",
+			Usage:       "Sends a query to a DNS server, or resolves it iteratively",
+			Description: "Usage: dns-workbench query [options] name [type]",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "server",
+					Value: "127.0.0.1",
+					Usage: "Address of the DNS server to query, the port defaults to 53, or 853 for tls",
+				},
+				cli.StringFlag{
+					Name:  "type",
+					Value: "A",
+					Usage: "Type to query for, can also be given after the name",
+				},
+				cli.StringFlag{
+					Name:  "protocol",
+					Value: "udp",
+					Usage: "Protocol to send the query over, either udp, tcp or tls",
+				},
+				cli.BoolFlag{
+					Name:  "edns",
+					Usage: "Add an EDNS0 OPT record to the query",
+				},
+				cli.IntFlag{
+					Name:  "bufsize",
+					Value: dns.DefaultMsgSize,
+					Usage: "UDP buffer size advertised with EDNS0",
+				},
+				cli.BoolFlag{
+					Name:  "do",
+					Usage: "Set the DNSSEC OK bit, implies --edns",
+				},
+				cli.BoolFlag{
+					Name:  "norecurse",
+					Usage: "Don't set the RD bit",
+				},
+				cli.StringFlag{
+					Name:  "tsig-key",
+					Usage: "TSIG key to sign the query with in the form name:base64-secret",
+				},
+				cli.StringFlag{
+					Name:  "tsig-algorithm",
+					Value: "hmac-sha256",
+					Usage: "TSIG algorithm, either hmac-md5, hmac-sha1 or hmac-sha256",
+				},
+				cli.BoolFlag{
+					Name:  "tls-insecure",
+					Usage: "Don't verify the certificate of the server with --protocol tls",
+				},
+				cli.DurationFlag{
+					Name:  "timeout",
+					Value: time.Second * 2,
+					Usage: "How long to wait for a response",
+				},
+				cli.StringFlag{
+					Name:  "format",
+					Value: "text",
+					Usage: "Output format, either text or json",
+				},
+				cli.StringSliceFlag{
+					Name:  "expect",
+					Value: &cli.StringSlice{},
+					Usage: "Value of an answer record that must be returned, exits with status 1 unless the answers are exactly these, can be repeated",
+				},
+				cli.StringFlag{
+					Name:  "expect-rcode",
+					Usage: "Rcode the response must have, exits with status 1 if it doesn't (default: NOERROR with --expect)",
+				},
+				cli.BoolFlag{
+					Name:  "iterate",
+					Usage: "Resolve the name iteratively starting from the root servers instead",
+				},
+				cli.StringFlag{
+					Name:  "root-hints",
+					Usage: "Path to a root hints file used with --iterate, defaults to the real root servers",
+				},
+				cli.StringFlag{
+					Name:  "ns-port",
+					Value: "53",
+					Usage: "Port nameservers are queried on with --iterate",
+				},
+				cli.BoolFlag{
+					Name:  "trace",
+					Usage: "Print every response received while resolving with --iterate",
+				},
+			},
+			Action: func(c *cli.Context) {
+				logger := log.New(os.Stderr, "[dns-wb] ", log.Flags())
+
+				args := c.Args()
+				if len(args) == 0 || len(args) > 2 {
+					logger.Fatalf("Usage: dns-workbench query [options] name [type]\n")
+				}
+				typeStr := c.String("type")
+				if len(args) == 2 {
+					typeStr = args[1]
+				}
+				name := dns.Fqdn(args[0])
+				qType, present := dns.StringToType[strings.ToUpper(typeStr)]
+				if !present {
+					logger.Fatalf("Invalid type %s\n", typeStr)
+				}
+				format := c.String("format")
+				if format != "text" && format != "json" {
+					logger.Fatalf("Invalid output format %s\n", format)
+				}
+
+				var resp *dns.Msg
+				if c.Bool("iterate") {
+					resolver, err := newResolver(c.String("root-hints"), c.String("ns-port"))
+					if err != nil {
+						logger.Fatalf("Failed to load root hints: %s\n", err)
+					}
+					if c.Bool("trace") {
+						resolver.Trace = func(server string, m *dns.Msg) {
+							if format == "json" {
+								content, _ := json.Marshal(workbench.NewJSONResponse(m))
+								fmt.Printf("%s\n", content)
+								return
+							}
+							fmt.Printf(";; Response from %s\n%s\n", server, m)
+						}
+					}
+					resp, err = resolver.Resolve(name, qType)
+					if err != nil {
+						logger.Fatalf("Failed to resolve %s: %s\n", name, err)
+					}
+				} else {
+					m := new(dns.Msg)
+					m.SetQuestion(name, qType)
+					m.RecursionDesired = !c.Bool("norecurse")
+					if c.Bool("edns") || c.Bool("do") {
+						m.SetEdns0(uint16(c.Int("bufsize")), c.Bool("do"))
+					}
+					var tsig map[string]string
+					if k := c.String("tsig-key"); k != "" {
+						fields := strings.SplitN(k, ":", 2)
+						if len(fields) != 2 {
+							logger.Fatalf("Invalid TSIG key: %s\n", k)
+						}
+						algorithm, present := map[string]string{
+							"hmac-md5":    dns.HmacMD5,
+							"hmac-sha1":   dns.HmacSHA1,
+							"hmac-sha256": dns.HmacSHA256,
+						}[strings.ToLower(c.String("tsig-algorithm"))]
+						if !present {
+							logger.Fatalf("Invalid TSIG algorithm: %s\n", c.String("tsig-algorithm"))
+						}
+						keyName := dns.Fqdn(fields[0])
+						tsig = map[string]string{keyName: fields[1]}
+						m.SetTsig(keyName, algorithm, 300, time.Now().Unix())
+					}
+					protocol := c.String("protocol")
+					tlsConfig := &tls.Config{InsecureSkipVerify: c.Bool("tls-insecure")}
+					var err error
+					resp, err = sendQuery(m, queryServer(c.String("server"), protocol), protocol, tsig, c.Duration("timeout"), tlsConfig)
+					if err != nil {
+						logger.Fatalf("Query failed: %s\n", err)
+					}
+				}
+
+				if format == "json" {
+					content, err := json.MarshalIndent(workbench.NewJSONResponse(resp), "", "  ")
+					if err != nil {
+						logger.Fatalf("Failed to marshal response: %s\n", err)
+					}
+					fmt.Printf("%s\n", content)
+				} else {
+					fmt.Println(resp)
+				}
+
+				expected := c.StringSlice("expect")
+				rcode := c.String("expect-rcode")
+				if len(expected) > 0 || rcode != "" {
+					if rcode == "" {
+						rcode = "NOERROR"
+					}
+					if len(expected) == 0 {
+						expected = nil
+					}
+					err := checkExpected(resp, dns.Question{Name: name, Qtype: qType, Qclass: dns.ClassINET}, rcode, expected)
+					if err != nil {
+						logger.Printf("%s\n", err)
+						os.Exit(1)
 					}
-					logger.Fatalf("Failed to reload zones: %s\n", apiErr)
-					return
 				}
-				logger.Printf("Succesesfully reloaded zones\n")
 			},
 		},
 	}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rolandshoemaker/dns-workbench/workbench"
+)
+
+func TestParseListener(t *testing.T) {
+	for _, tc := range []struct {
+		spec string
+		want workbench.Listener
+	}{
+		{"127.0.0.1:53", workbench.Listener{Addr: "127.0.0.1:53"}},
+		{"udp/127.0.0.1:53", workbench.Listener{Addr: "127.0.0.1:53", Nets: []string{"udp"}}},
+		{"udp,tcp/[::1]:53=internal", workbench.Listener{Addr: "[::1]:53", Nets: []string{"udp", "tcp"}, View: "internal"}},
+		{"127.0.0.1:53=internal", workbench.Listener{Addr: "127.0.0.1:53", View: "internal"}},
+		// DNS over TLS defaults to port 853
+		{"tls/127.0.0.1", workbench.Listener{Addr: "127.0.0.1:853", Nets: []string{"tls"}}},
+		{"tls/[::1]", workbench.Listener{Addr: "[::1]:853", Nets: []string{"tls"}}},
+	} {
+		got, err := parseListener(tc.spec)
+		if err != nil {
+			t.Errorf("%s: failed to parse: %s", tc.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %+v, expected %+v", tc.spec, got, tc.want)
+		}
+	}
+	for _, spec := range []string{"127.0.0.1", "udp/127.0.0.1", "=internal"} {
+		if _, err := parseListener(spec); err == nil {
+			t.Errorf("%s: parsed without an error", spec)
+		}
+	}
+}
+
+func TestWatchPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "zones.yml")
+	zonesDir := filepath.Join(dir, "zones.d")
+	err = os.Mkdir(zonesDir, 0755)
+	if err != nil {
+		t.Fatalf("Failed to create zone directory: %s", err)
+	}
+	write := func(path, contents string) {
+		err := ioutil.WriteFile(path, []byte(contents), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write %s: %s", path, err)
+		}
+	}
+	write(file, "zones: {}\n")
+
+	changed := make(chan struct{}, 1)
+	go watchPaths([]string{file}, []string{zonesDir}, 10*time.Millisecond, changed)
+	select {
+	case <-changed:
+		t.Fatalf("Change reported before anything changed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	extra := filepath.Join(zonesDir, "extra.yml")
+	for _, tc := range []struct {
+		desc   string
+		change func()
+	}{
+		{"file modified", func() { write(file, "zones:\n  example.com: {}\n") }},
+		{"file added to directory", func() { write(extra, "zones: {}\n") }},
+		{"file in directory modified", func() { write(extra, "zones:\n  example.org: {}\n") }},
+		{"file removed from directory", func() { os.Remove(extra) }},
+		{"file removed", func() { os.Remove(file) }},
+	} {
+		tc.change()
+		select {
+		case <-changed:
+		case <-time.After(time.Second):
+			t.Errorf("%s: no change reported", tc.desc)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/github.com/miekg/dns"
+)
+
+// queryServer returns server with the default port for protocol added if it
+// doesn't have one
+func queryServer(server, protocol string) string {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	port := "53"
+	if protocol == "tls" {
+		port = "853"
+	}
+	return net.JoinHostPort(strings.Trim(server, "[]"), port)
+}
+
+// sendQuery sends m to server over udp, tcp or tls, the vendored client
+// only supports the first two
+func sendQuery(m *dns.Msg, server, protocol string, tsig map[string]string, timeout time.Duration, tlsConfig *tls.Config) (*dns.Msg, error) {
+	switch protocol {
+	case "udp", "tcp":
+		c := &dns.Client{
+			Net:          protocol,
+			TsigSecret:   tsig,
+			DialTimeout:  timeout,
+			ReadTimeout:  timeout,
+			WriteTimeout: timeout,
+		}
+		resp, _, err := c.Exchange(m, server)
+		return resp, err
+	case "tls":
+		return exchangeTLS(m, server, tsig, timeout, tlsConfig)
+	}
+	return nil, fmt.Errorf("Invalid protocol %s", protocol)
+}
+
+// exchangeTLS sends m to server using DNS over TLS
+func exchangeTLS(m *dns.Msg, server string, tsig map[string]string, timeout time.Duration, config *tls.Config) (*dns.Msg, error) {
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", server, config)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	var out []byte
+	var requestMAC string
+	t := m.IsTsig()
+	if t != nil {
+		out, requestMAC, err = dns.TsigGenerate(m, tsig[t.Hdr.Name], "", false)
+	} else {
+		out, err = m.Pack()
+	}
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 2, len(out)+2)
+	binary.BigEndian.PutUint16(buf, uint16(len(out)))
+	_, err = conn.Write(append(buf, out...))
+	if err != nil {
+		return nil, err
+	}
+
+	var length uint16
+	err = binary.Read(conn, binary.BigEndian, &length)
+	if err != nil {
+		return nil, err
+	}
+	in := make([]byte, length)
+	_, err = io.ReadFull(conn, in)
+	if err != nil {
+		return nil, err
+	}
+	resp := new(dns.Msg)
+	err = resp.Unpack(in)
+	if err != nil {
+		return nil, err
+	}
+	if t != nil && resp.IsTsig() != nil {
+		err = dns.TsigVerify(in, tsig[t.Hdr.Name], requestMAC, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if resp.Id != m.Id {
+		return nil, dns.ErrId
+	}
+	return resp, nil
+}
+
+// answerValues returns the sorted presentation values of the records of type
+// qType in the answer section of m
+func answerValues(m *dns.Msg, qType uint16) []string {
+	values := []string{}
+	for _, rr := range m.Answer {
+		hdr := rr.Header()
+		if hdr.Rrtype == qType {
+			values = append(values, strings.TrimSpace(strings.TrimPrefix(rr.String(), hdr.String())))
+		}
+	}
+	sort.Strings(values)
+	return values
+}
+
+// checkExpected returns an error if the rcode of m isn't rcode or the values of
+// the answers to q don't match expected, in any order. q is the question that
+// was sent since servers can leave it out of error responses. Expected values
+// are normalized by parsing them as records, so 2001:db8:0::1 matches
+// 2001:db8::1.
+func checkExpected(m *dns.Msg, q dns.Question, rcode string, expected []string) error {
+	if got := dns.RcodeToString[m.Rcode]; !strings.EqualFold(got, rcode) {
+		return fmt.Errorf("Expected rcode %s, got %s", strings.ToUpper(rcode), got)
+	}
+	if expected == nil {
+		return nil
+	}
+	want := []string{}
+	for _, e := range expected {
+		rr, err := dns.NewRR(fmt.Sprintf("%s %s %s", q.Name, dns.TypeToString[q.Qtype], e))
+		if err == nil && rr != nil {
+			e = strings.TrimSpace(strings.TrimPrefix(rr.String(), rr.Header().String()))
+		}
+		want = append(want, e)
+	}
+	sort.Strings(want)
+	got := answerValues(m, q.Qtype)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		return fmt.Errorf("Expected answers [%s], got [%s]", strings.Join(want, ", "), strings.Join(got, ", "))
+	}
+	return nil
+}
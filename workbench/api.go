@@ -0,0 +1,118 @@
+package workbench
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func sendError(err string, w http.ResponseWriter) {
+	w.WriteHeader(400)
+	w.Write([]byte(err))
+}
+
+func (wb *Workbench) apiReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		wb.mu.RLock()
+		rz, tag := wb.rz, etag(wb.rz)
+		wb.mu.RUnlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", tag)
+		json.NewEncoder(w).Encode(rz)
+		return
+	}
+	if r.Method != "POST" {
+		sendError("Method not supported", w)
+		return
+	}
+
+	mode := r.URL.Query().Get("mode")
+	if !validMode(mode) {
+		sendError(fmt.Sprintf("Invalid reload mode: %s", mode), w)
+		return
+	}
+
+	rStart := time.Now()
+	var rz RawZones
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		sendError(err.Error(), w)
+		return
+	}
+	err = json.Unmarshal(body, &rz)
+	if dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run")); dryRun {
+		wb.apiReloadPreview(w, r, rz, err)
+		return
+	}
+	if err == nil {
+		err = wb.UpdateZones(mode, rz, r.Header.Get("If-Match"))
+	}
+	if err == ErrETagMismatch {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	wb.finishReload(rStart, err)
+	if err != nil {
+		sendError(err.Error(), w)
+		return
+	}
+	w.Header().Set("ETag", wb.ETag())
+}
+
+// apiReloadPreview reports the changes a reload would make without applying
+// them, err is the error from decoding rz
+func (wb *Workbench) apiReloadPreview(w http.ResponseWriter, r *http.Request, rz RawZones, err error) {
+	var changes []RRsetChange
+	if err == nil {
+		changes, err = wb.PreviewUpdate(r.URL.Query().Get("mode"), rz, r.Header.Get("If-Match"))
+	}
+	if err == ErrETagMismatch {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		w.Write([]byte(err.Error()))
+		return
+	} else if err != nil {
+		sendError(err.Error(), w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", wb.ETag())
+	json.NewEncoder(w).Encode(changes)
+}
+
+func (wb *Workbench) apiShutdown(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		sendError("Method not supported", w)
+		return
+	}
+
+	wb.l.Printf("Shutdown requested via the API\n")
+	wb.RequestShutdown()
+}
+
+func (wb *Workbench) apiScriptsReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		sendError("Method not supported", w)
+		return
+	}
+	wb.ResetScripts()
+}
+
+// APIHandler returns a handler serving the workbench HTTP API
+func (wb *Workbench) APIHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/reload", wb.apiReload)
+	mux.HandleFunc("/api/shutdown", wb.apiShutdown)
+	mux.HandleFunc("/api/scripts/reset", wb.apiScriptsReset)
+	mux.HandleFunc("/metrics", wb.apiMetrics)
+	mux.HandleFunc("/dns-query", wb.apiDNSQuery)
+	mux.HandleFunc("/resolve", wb.apiResolve)
+	if wb.qh != nil {
+		mux.HandleFunc("/api/queries", wb.apiQueries)
+		mux.HandleFunc("/api/queries/wait", wb.apiQueriesWait)
+	}
+	return mux
+}
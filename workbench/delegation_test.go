@@ -0,0 +1,161 @@
+package workbench_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/github.com/miekg/dns"
+	"github.com/rolandshoemaker/dns-workbench/workbench"
+	"github.com/rolandshoemaker/dns-workbench/workbench/workbenchtest"
+)
+
+const delegationZones = `
+views:
+  - name: root
+    nameserver: a.root-servers.net
+    zones:
+      .:
+        .:
+          ns: [a.root-servers.net.]
+        a.root-servers.net:
+          a: [127.0.0.2]
+        com:
+          ns: [a.gtld-servers.net.]
+        a.gtld-servers.net:
+          a: [127.0.0.3]
+          aaaa: ["::3"]
+  - name: com
+    nameserver: a.gtld-servers.net
+    zones:
+      com:
+        example.com:
+          ns: [ns1.example.com., ns2.example.com.]
+        ns1.example.com:
+          a: [127.0.0.4]
+        ns2.example.com:
+          a: [127.0.0.5]
+  - name: example
+    nameserver: ns1.example.com
+    zones:
+      example.com:
+        www.example.com:
+          a: [192.0.2.1]
+  - name: lame
+    nameserver: ns2.example.com
+`
+
+func TestDelegation(t *testing.T) {
+	views := []string{"root", "com", "example", "lame"}
+	config := workbench.Config{}
+	for _, v := range views {
+		config.Listeners = append(config.Listeners, workbench.Listener{Addr: "127.0.0.1:0", View: v})
+	}
+	s := workbenchtest.NewServerWithConfig(t, delegationZones, config)
+	addrs := s.Addrs()
+	if len(addrs) != len(views) {
+		t.Fatalf("Expected %d listener addresses, got %q", len(views), addrs)
+	}
+	addr := make(map[string]string)
+	for i, v := range views {
+		addr[v] = addrs[i]
+	}
+
+	for _, tc := range []struct {
+		desc  string
+		view  string
+		name  string
+		qType uint16
+		rcode int
+		aa    bool
+		// presentation values of the answer, authority and additional records
+		answer, ns, extra []string
+	}{
+		{
+			desc:  "referral with IPv4 and IPv6 glue",
+			view:  "root",
+			name:  "www.example.com.",
+			qType: dns.TypeA,
+			ns:    []string{"a.gtld-servers.net."},
+			extra: []string{"127.0.0.3", "::3"},
+		},
+		{
+			desc:   "records for the nameserver",
+			view:   "root",
+			name:   "a.root-servers.net.",
+			qType:  dns.TypeA,
+			aa:     true,
+			answer: []string{"127.0.0.2"},
+		},
+		{
+			desc:  "referral with several nameservers",
+			view:  "com",
+			name:  "www.example.com.",
+			qType: dns.TypeA,
+			ns:    []string{"ns1.example.com.", "ns2.example.com."},
+			extra: []string{"127.0.0.4", "127.0.0.5"},
+		},
+		{
+			desc:  "delegation point without the type",
+			view:  "com",
+			name:  "example.com.",
+			qType: dns.TypeA,
+			ns:    []string{"ns1.example.com.", "ns2.example.com."},
+			extra: []string{"127.0.0.4", "127.0.0.5"},
+		},
+		{
+			desc:   "delegation point with the type",
+			view:   "com",
+			name:   "example.com.",
+			qType:  dns.TypeNS,
+			aa:     true,
+			answer: []string{"ns1.example.com.", "ns2.example.com."},
+		},
+		{
+			desc:   "authoritative answer",
+			view:   "example",
+			name:   "www.example.com.",
+			qType:  dns.TypeA,
+			aa:     true,
+			answer: []string{"192.0.2.1"},
+		},
+		{
+			desc:  "name outside the view's zones",
+			view:  "example",
+			name:  "www.example.org.",
+			qType: dns.TypeA,
+			rcode: dns.RcodeRefused,
+		},
+		{
+			desc:  "lame view",
+			view:  "lame",
+			name:  "www.example.com.",
+			qType: dns.TypeA,
+			rcode: dns.RcodeRefused,
+		},
+	} {
+		resp := ask(t, addr[tc.view], tc.name, tc.qType)
+		if resp.Rcode != tc.rcode || resp.Authoritative != tc.aa {
+			t.Errorf("%s: got %s with AA %t, expected %s with AA %t", tc.desc,
+				dns.RcodeToString[resp.Rcode], resp.Authoritative, dns.RcodeToString[tc.rcode], tc.aa)
+		}
+		if got := values(resp.Answer); !reflect.DeepEqual(got, nonNil(tc.answer)) {
+			t.Errorf("%s: got answer %q, expected %q", tc.desc, got, tc.answer)
+		}
+		// authoritative answers carry the zone's records in the authority
+		// section, only referrals are checked
+		if got := values(resp.Ns); tc.ns != nil && !reflect.DeepEqual(got, tc.ns) {
+			t.Errorf("%s: got authority %q, expected %q", tc.desc, got, tc.ns)
+		}
+		if got := values(resp.Extra); !reflect.DeepEqual(got, nonNil(tc.extra)) {
+			t.Errorf("%s: got additional %q, expected %q", tc.desc, got, tc.extra)
+		}
+	}
+}
+
+// nonNil returns v, or an empty slice if it is nil, to compare with values
+func nonNil(v []string) []string {
+	if v == nil {
+		return []string{}
+	}
+	return v
+}
@@ -0,0 +1,152 @@
+package workbench
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/github.com/miekg/dns"
+)
+
+// Kinds of RRsetChange
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeChanged = "changed"
+)
+
+// RRsetChange is an RRset that differs between two zone definitions, Old and
+// New hold the sorted presentation values on either side
+type RRsetChange struct {
+	View   string   `json:"view,omitempty"`
+	Zone   string   `json:"zone"`
+	Name   string   `json:"name"`
+	Type   string   `json:"type"`
+	Change string   `json:"change"`
+	Old    []string `json:"old,omitempty"`
+	New    []string `json:"new,omitempty"`
+}
+
+type rrsetKey struct {
+	zone, name, rrType string
+}
+
+// rrsets returns the RRsets in zs with normalized names and values
+func rrsets(zs map[string]map[string]map[string][]string) (map[rrsetKey][]string, error) {
+	sets := make(map[rrsetKey][]string)
+	for zone, hosts := range zs {
+		zone = strings.ToLower(dns.Fqdn(zone))
+		for host, types := range hosts {
+			host = strings.ToLower(dns.Fqdn(host))
+			for t, values := range types {
+				key := rrsetKey{zone, host, strings.ToUpper(t)}
+				for _, presentation := range values {
+					rr, err := newRecord(host, t, presentation)
+					if err != nil {
+						return nil, err
+					}
+					value := strings.TrimSpace(strings.TrimPrefix(rr.String(), rr.Header().String()))
+					if !containsString(sets[key], value) {
+						sets[key] = append(sets[key], value)
+					}
+				}
+				sort.Strings(sets[key])
+			}
+		}
+	}
+	return sets, nil
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// diffZones returns the changes between the zones in old and updated
+func diffZones(view string, old, updated map[string]map[string]map[string][]string) ([]RRsetChange, error) {
+	oldSets, err := rrsets(old)
+	if err != nil {
+		return nil, err
+	}
+	newSets, err := rrsets(updated)
+	if err != nil {
+		return nil, err
+	}
+	changes := []RRsetChange{}
+	add := func(key rrsetKey, change string, o, n []string) {
+		changes = append(changes, RRsetChange{
+			View:   view,
+			Zone:   key.zone,
+			Name:   key.name,
+			Type:   key.rrType,
+			Change: change,
+			Old:    o,
+			New:    n,
+		})
+	}
+	for key, o := range oldSets {
+		n, present := newSets[key]
+		if !present || len(n) == 0 {
+			if len(o) > 0 {
+				add(key, ChangeRemoved, o, nil)
+			}
+		} else if strings.Join(o, "\n") != strings.Join(n, "\n") {
+			add(key, ChangeChanged, o, n)
+		}
+	}
+	for key, n := range newSets {
+		if o, present := oldSets[key]; (!present || len(o) == 0) && len(n) > 0 {
+			add(key, ChangeAdded, nil, n)
+		}
+	}
+	return changes, nil
+}
+
+// Diff returns the RRsets that are added, removed or changed in the top
+// level zones and each view when going from old to updated, ordered by view,
+// zone, name and type. Scripts, ordering policies and subnets aren't
+// compared.
+func Diff(old, updated RawZones) ([]RRsetChange, error) {
+	changes, err := diffZones("", old.Zones, updated.Zones)
+	if err != nil {
+		return nil, err
+	}
+	oldViews := make(map[string]map[string]map[string]map[string][]string)
+	names := []string{}
+	for _, rv := range old.Views {
+		oldViews[rv.Name] = rv.Zones
+		names = append(names, rv.Name)
+	}
+	newViews := make(map[string]map[string]map[string]map[string][]string)
+	for _, rv := range updated.Views {
+		newViews[rv.Name] = rv.Zones
+		if _, present := oldViews[rv.Name]; !present {
+			names = append(names, rv.Name)
+		}
+	}
+	for _, name := range names {
+		viewChanges, err := diffZones(name, oldViews[name], newViews[name])
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, viewChanges...)
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		a, b := changes[i], changes[j]
+		if a.View != b.View {
+			return a.View < b.View
+		}
+		if a.Zone != b.Zone {
+			return a.Zone < b.Zone
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Type < b.Type
+	})
+	return changes, nil
+}
@@ -0,0 +1,98 @@
+package workbench
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	for _, tc := range []struct {
+		desc    string
+		old     RawZones
+		updated RawZones
+		want    []RRsetChange
+		err     bool
+	}{
+		{
+			desc: "names and values are normalized",
+			old: RawZones{Zones: zonesOf(map[string]rawHosts{"Example.com": {
+				"WWW.example.com": {"a": {"192.0.2.1", "192.0.2.1"}, "AAAA": {"2001:db8:0::1"}},
+			}})},
+			updated: RawZones{Zones: zonesOf(map[string]rawHosts{"example.com.": {
+				"www.example.com.": {"A": {"192.0.2.1"}, "aaaa": {"2001:db8::1"}},
+			}})},
+			want: []RRsetChange{},
+		},
+		{
+			desc: "empty RRsets are the same as missing ones",
+			old: RawZones{Zones: zonesOf(map[string]rawHosts{"example.com": {
+				"example.com": {"a": {"192.0.2.1"}, "txt": {}},
+			}})},
+			updated: RawZones{Zones: zonesOf(map[string]rawHosts{"example.com": {
+				"example.com":     {"a": {"192.0.2.1"}},
+				"www.example.com": {"a": {}},
+			}})},
+			want: []RRsetChange{},
+		},
+		{
+			desc: "added, removed and changed RRsets are sorted",
+			old: RawZones{Zones: zonesOf(map[string]rawHosts{
+				"example.com": {
+					"www.example.com": {"a": {"192.0.2.2", "192.0.2.1"}},
+					"old.example.com": {"txt": {`"gone"`}},
+				},
+				"other.com": {"other.com": {"a": {"192.0.2.3"}}},
+			})},
+			updated: RawZones{Zones: zonesOf(map[string]rawHosts{
+				"example.com": {
+					"www.example.com": {"a": {"192.0.2.1", "192.0.2.4"}},
+					"new.example.com": {"aaaa": {"2001:db8::1"}},
+				},
+			})},
+			want: []RRsetChange{
+				{Zone: "example.com.", Name: "new.example.com.", Type: "AAAA", Change: ChangeAdded, New: []string{"2001:db8::1"}},
+				{Zone: "example.com.", Name: "old.example.com.", Type: "TXT", Change: ChangeRemoved, Old: []string{`"gone"`}},
+				{Zone: "example.com.", Name: "www.example.com.", Type: "A", Change: ChangeChanged, Old: []string{"192.0.2.1", "192.0.2.2"}, New: []string{"192.0.2.1", "192.0.2.4"}},
+				{Zone: "other.com.", Name: "other.com.", Type: "A", Change: ChangeRemoved, Old: []string{"192.0.2.3"}},
+			},
+		},
+		{
+			desc: "views are compared by name",
+			old: RawZones{Views: []RawView{
+				{Name: "internal", Zones: zonesOf(map[string]rawHosts{"example.com": {"example.com": {"a": {"10.0.0.1"}}}})},
+				{Name: "old", Zones: zonesOf(map[string]rawHosts{"example.com": {"example.com": {"a": {"10.0.0.2"}}}})},
+			}},
+			updated: RawZones{Views: []RawView{
+				{Name: "lab", Zones: zonesOf(map[string]rawHosts{"example.com": {"example.com": {"a": {"10.0.0.3"}}}})},
+				{Name: "internal", Zones: zonesOf(map[string]rawHosts{"example.com": {"example.com": {"a": {"10.0.0.1"}}}})},
+			}},
+			want: []RRsetChange{
+				{View: "lab", Zone: "example.com.", Name: "example.com.", Type: "A", Change: ChangeAdded, New: []string{"10.0.0.3"}},
+				{View: "old", Zone: "example.com.", Name: "example.com.", Type: "A", Change: ChangeRemoved, Old: []string{"10.0.0.2"}},
+			},
+		},
+		{
+			desc: "invalid records",
+			old:  RawZones{},
+			updated: RawZones{Zones: zonesOf(map[string]rawHosts{"example.com": {
+				"example.com": {"a": {"not an address"}},
+			}})},
+			err: true,
+		},
+	} {
+		got, err := Diff(tc.old, tc.updated)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s: Diff succeeded, expected an error", tc.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Diff failed: %s", tc.desc, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: Diff = %+v, expected %+v", tc.desc, got, tc.want)
+		}
+	}
+}
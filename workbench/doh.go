@@ -0,0 +1,255 @@
+package workbench
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/github.com/miekg/dns"
+)
+
+// httpWriter is a dns.ResponseWriter that keeps the response so it can be
+// sent as a DNS over HTTPS reply
+type httpWriter struct {
+	tsigState
+	local  net.Addr
+	remote net.Addr
+	msg    []byte
+}
+
+func newHTTPWriter(r *http.Request) *httpWriter {
+	w := &httpWriter{
+		local:  &net.TCPAddr{},
+		remote: &net.TCPAddr{},
+	}
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		w.local = addr
+	}
+	if addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil {
+		w.remote = addr
+	}
+	return w
+}
+
+func (w *httpWriter) LocalAddr() net.Addr  { return w.local }
+func (w *httpWriter) RemoteAddr() net.Addr { return w.remote }
+
+func (w *httpWriter) WriteMsg(m *dns.Msg) error {
+	data, err := w.pack(m)
+	if err != nil {
+		return err
+	}
+	w.msg = data
+	return nil
+}
+
+func (w *httpWriter) Write(m []byte) (int, error) {
+	w.msg = append([]byte{}, m...)
+	return len(m), nil
+}
+
+func (w *httpWriter) Close() error { return nil }
+func (w *httpWriter) Hijack()      {}
+
+// serveHTTPQuery answers the query in buf and returns the packed response
+func (wb *Workbench) serveHTTPQuery(r *http.Request, buf []byte) ([]byte, error) {
+	req := new(dns.Msg)
+	err := req.Unpack(buf)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid DNS message: %v", err)
+	}
+	w := newHTTPWriter(r)
+	w.verify(wb.tsig, buf, req)
+	wb.dnsHandler(w, req, "")
+	if w.msg == nil {
+		return nil, fmt.Errorf("No response")
+	}
+	return w.msg, nil
+}
+
+// minTTL returns the lowest TTL in the answer and authority sections of m
+func minTTL(m *dns.Msg) (uint32, bool) {
+	var ttl uint32
+	found := false
+	for _, rr := range append(append([]dns.RR{}, m.Answer...), m.Ns...) {
+		if !found || rr.Header().Ttl < ttl {
+			ttl = rr.Header().Ttl
+			found = true
+		}
+	}
+	return ttl, found
+}
+
+// apiDNSQuery serves DNS over HTTPS as described in RFC 8484
+func (wb *Workbench) apiDNSQuery(w http.ResponseWriter, r *http.Request) {
+	var buf []byte
+	switch r.Method {
+	case "GET":
+		param := r.URL.Query().Get("dns")
+		if param == "" {
+			sendError("Missing dns parameter", w)
+			return
+		}
+		var err error
+		buf, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(param, "="))
+		if err != nil {
+			sendError("Invalid dns parameter", w)
+			return
+		}
+	case "POST":
+		if r.Header.Get("Content-Type") != "application/dns-message" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		var err error
+		buf, err = ioutil.ReadAll(r.Body)
+		if err != nil {
+			sendError(err.Error(), w)
+			return
+		}
+	default:
+		sendError("Method not supported", w)
+		return
+	}
+
+	resp, err := wb.serveHTTPQuery(r, buf)
+	if err != nil {
+		sendError(err.Error(), w)
+		return
+	}
+	m := new(dns.Msg)
+	if m.Unpack(resp) == nil {
+		if ttl, found := minTTL(m); found {
+			w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", ttl))
+		}
+	}
+	w.Header().Set("Content-Type", "application/dns-message")
+	w.Write(resp)
+}
+
+type jsonQuestion struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+}
+
+type jsonRR struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+	TTL  uint32 `json:"TTL"`
+	Data string `json:"data"`
+}
+
+// JSONResponse is a DNS message in the JSON format used by the Google and
+// Cloudflare DNS over HTTPS APIs
+type JSONResponse struct {
+	Status     int            `json:"Status"`
+	TC         bool           `json:"TC"`
+	RD         bool           `json:"RD"`
+	RA         bool           `json:"RA"`
+	AD         bool           `json:"AD"`
+	CD         bool           `json:"CD"`
+	Question   []jsonQuestion `json:"Question"`
+	Answer     []jsonRR       `json:"Answer,omitempty"`
+	Authority  []jsonRR       `json:"Authority,omitempty"`
+	Additional []jsonRR       `json:"Additional,omitempty"`
+}
+
+// NewJSONResponse converts m to the JSON format, OPT and TSIG records are
+// left out
+func NewJSONResponse(m *dns.Msg) JSONResponse {
+	jr := JSONResponse{
+		Status:     m.Rcode,
+		TC:         m.Truncated,
+		RD:         m.RecursionDesired,
+		RA:         m.RecursionAvailable,
+		AD:         m.AuthenticatedData,
+		CD:         m.CheckingDisabled,
+		Answer:     jsonRRs(m.Answer),
+		Authority:  jsonRRs(m.Ns),
+		Additional: jsonRRs(m.Extra),
+	}
+	for _, q := range m.Question {
+		jr.Question = append(jr.Question, jsonQuestion{Name: q.Name, Type: q.Qtype})
+	}
+	return jr
+}
+
+func jsonRRs(rrs []dns.RR) []jsonRR {
+	out := []jsonRR{}
+	for _, rr := range rrs {
+		hdr := rr.Header()
+		if hdr.Rrtype == dns.TypeOPT || hdr.Rrtype == dns.TypeTSIG {
+			continue
+		}
+		out = append(out, jsonRR{
+			Name: hdr.Name,
+			Type: hdr.Rrtype,
+			TTL:  hdr.Ttl,
+			Data: strings.TrimSpace(strings.TrimPrefix(rr.String(), hdr.String())),
+		})
+	}
+	return out
+}
+
+// parseBool parses the boolean query parameters of the JSON API, which
+// accept 1/true and 0/false
+func parseBool(value string) bool {
+	b, _ := strconv.ParseBool(value)
+	return b
+}
+
+// apiResolve serves the JSON DNS over HTTPS API used by Google and Cloudflare
+func (wb *Workbench) apiResolve(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		sendError("Method not supported", w)
+		return
+	}
+	params := r.URL.Query()
+	name := params.Get("name")
+	if name == "" {
+		sendError("Missing name parameter", w)
+		return
+	}
+	qType := dns.TypeA
+	if t := params.Get("type"); t != "" {
+		if n, err := strconv.ParseUint(t, 10, 16); err == nil {
+			qType = uint16(n)
+		} else if st, present := dns.StringToType[strings.ToUpper(t)]; present {
+			qType = st
+		} else {
+			sendError("Invalid type parameter", w)
+			return
+		}
+	}
+
+	req := new(dns.Msg)
+	req.SetQuestion(dns.Fqdn(name), qType)
+	req.CheckingDisabled = parseBool(params.Get("cd"))
+	if parseBool(params.Get("do")) {
+		req.SetEdns0(dns.DefaultMsgSize, true)
+	}
+	buf, err := req.Pack()
+	if err != nil {
+		sendError(err.Error(), w)
+		return
+	}
+	resp, err := wb.serveHTTPQuery(r, buf)
+	if err != nil {
+		sendError(err.Error(), w)
+		return
+	}
+	m := new(dns.Msg)
+	err = m.Unpack(resp)
+	if err != nil {
+		sendError(err.Error(), w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/dns-json")
+	json.NewEncoder(w).Encode(NewJSONResponse(m))
+}
@@ -0,0 +1,231 @@
+package workbench_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/github.com/miekg/dns"
+	"github.com/rolandshoemaker/dns-workbench/workbench"
+)
+
+const dohZones = `
+views:
+  - name: local
+    match:
+      clients: [127.0.0.1/32]
+    zones:
+      example.com:
+        www.example.com:
+          a: [192.0.2.2]
+          aaaa: ["2001:db8::2"]
+zones:
+  example.com:
+    www.example.com:
+      a: [192.0.2.1]
+`
+
+// newDoHServer starts a workbench serving zonesYAML and its API, every query
+// it answers is sent on the returned channel
+func newDoHServer(t *testing.T, zonesYAML string) (*httptest.Server, chan *workbench.QueryEntry) {
+	logged := make(chan *workbench.QueryEntry, 10)
+	wb := newWorkbench(t, zonesYAML, workbench.Config{
+		OnQuery: func(qe *workbench.QueryEntry) { logged <- qe },
+	})
+	api := httptest.NewServer(wb.APIHandler())
+	t.Cleanup(func() {
+		api.Close()
+		wb.Stop()
+	})
+	return api, logged
+}
+
+// dohMessage checks resp is a successful DNS over HTTPS reply and returns the
+// DNS message it contains
+func dohMessage(t *testing.T, resp *http.Response) *dns.Msg {
+	t.Helper()
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read response: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Got status %d: %s", resp.StatusCode, body)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/dns-message" {
+		t.Errorf("Got content type %q, expected application/dns-message", ct)
+	}
+	m := new(dns.Msg)
+	err = m.Unpack(body)
+	if err != nil {
+		t.Fatalf("Failed to unpack response: %s", err)
+	}
+	return m
+}
+
+func TestDoH(t *testing.T) {
+	api, logged := newDoHServer(t, exampleZones)
+	m := new(dns.Msg)
+	m.SetQuestion("www.example.com.", dns.TypeA)
+	buf, err := m.Pack()
+	if err != nil {
+		t.Fatalf("Failed to pack query: %s", err)
+	}
+
+	for _, tc := range []struct {
+		desc string
+		send func() (*http.Response, error)
+	}{
+		{"GET", func() (*http.Response, error) {
+			return http.Get(api.URL + "/dns-query?dns=" + base64.RawURLEncoding.EncodeToString(buf))
+		}},
+		{"GET with padding", func() (*http.Response, error) {
+			return http.Get(api.URL + "/dns-query?dns=" + url.QueryEscape(base64.URLEncoding.EncodeToString(buf)))
+		}},
+		{"POST", func() (*http.Response, error) {
+			return http.Post(api.URL+"/dns-query", "application/dns-message", bytes.NewReader(buf))
+		}},
+	} {
+		resp, err := tc.send()
+		if err != nil {
+			t.Fatalf("%s: request failed: %s", tc.desc, err)
+		}
+		if cc := resp.Header.Get("Cache-Control"); !strings.HasPrefix(cc, "max-age=") {
+			t.Errorf("%s: got Cache-Control %q, expected a max-age", tc.desc, cc)
+		}
+		reply := dohMessage(t, resp)
+		if reply.Id != m.Id {
+			t.Errorf("%s: got ID %d, expected %d", tc.desc, reply.Id, m.Id)
+		}
+		if got := values(reply.Answer); !reflect.DeepEqual(got, []string{"192.0.2.1"}) {
+			t.Errorf("%s: got %q, expected 192.0.2.1", tc.desc, got)
+		}
+		select {
+		case qe := <-logged:
+			if qe.Protocol != "doh" {
+				t.Errorf("%s: query logged with protocol %q, expected doh", tc.desc, qe.Protocol)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("%s: query wasn't logged", tc.desc)
+		}
+	}
+}
+
+func TestDoHErrors(t *testing.T) {
+	api, _ := newDoHServer(t, exampleZones)
+	for _, tc := range []struct {
+		desc   string
+		send   func() (*http.Response, error)
+		status int
+	}{
+		{"missing dns parameter", func() (*http.Response, error) {
+			return http.Get(api.URL + "/dns-query")
+		}, http.StatusBadRequest},
+		{"invalid base64", func() (*http.Response, error) {
+			return http.Get(api.URL + "/dns-query?dns=not*base64")
+		}, http.StatusBadRequest},
+		{"invalid message", func() (*http.Response, error) {
+			return http.Get(api.URL + "/dns-query?dns=AAAA")
+		}, http.StatusBadRequest},
+		{"wrong content type", func() (*http.Response, error) {
+			return http.Post(api.URL+"/dns-query", "application/octet-stream", strings.NewReader("query"))
+		}, http.StatusUnsupportedMediaType},
+		{"unsupported method", func() (*http.Response, error) {
+			req, _ := http.NewRequest("PUT", api.URL+"/dns-query", nil)
+			return http.DefaultClient.Do(req)
+		}, http.StatusBadRequest},
+	} {
+		resp, err := tc.send()
+		if err != nil {
+			t.Fatalf("%s: request failed: %s", tc.desc, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s: got status %d, expected %d", tc.desc, resp.StatusCode, tc.status)
+		}
+	}
+}
+
+func TestDoHViews(t *testing.T) {
+	api, _ := newDoHServer(t, dohZones)
+	m := new(dns.Msg)
+	m.SetQuestion("www.example.com.", dns.TypeA)
+	buf, err := m.Pack()
+	if err != nil {
+		t.Fatalf("Failed to pack query: %s", err)
+	}
+	resp, err := http.Post(api.URL+"/dns-query", "application/dns-message", bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("Request failed: %s", err)
+	}
+	// the view is picked using the address of the HTTP client
+	if got := values(dohMessage(t, resp).Answer); !reflect.DeepEqual(got, []string{"192.0.2.2"}) {
+		t.Errorf("Got %q, expected the answer from the local view", got)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	api, logged := newDoHServer(t, dohZones)
+	for _, tc := range []struct {
+		desc   string
+		params url.Values
+		status int
+		want   []string
+	}{
+		{"default type", url.Values{"name": {"www.example.com"}}, dns.RcodeSuccess, []string{"192.0.2.2"}},
+		{"type name", url.Values{"name": {"www.example.com."}, "type": {"aaaa"}}, dns.RcodeSuccess, []string{"2001:db8::2"}},
+		{"type number", url.Values{"name": {"www.example.com"}, "type": {"1"}}, dns.RcodeSuccess, []string{"192.0.2.2"}},
+		{"missing name", url.Values{"name": {"missing.example.com"}}, dns.RcodeNameError, []string{}},
+	} {
+		resp, err := http.Get(api.URL + "/resolve?" + tc.params.Encode())
+		if err != nil {
+			t.Fatalf("%s: request failed: %s", tc.desc, err)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != "application/dns-json" {
+			t.Errorf("%s: got content type %q, expected application/dns-json", tc.desc, ct)
+		}
+		var jr workbench.JSONResponse
+		err = json.NewDecoder(resp.Body).Decode(&jr)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("%s: failed to decode response: %s", tc.desc, err)
+		}
+		if jr.Status != tc.status {
+			t.Errorf("%s: got status %d, expected %d", tc.desc, jr.Status, tc.status)
+		}
+		got := []string{}
+		for _, rr := range jr.Answer {
+			got = append(got, rr.Data)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %q, expected %q", tc.desc, got, tc.want)
+		}
+		select {
+		case qe := <-logged:
+			if qe.Protocol != "doh" {
+				t.Errorf("%s: query logged with protocol %q, expected doh", tc.desc, qe.Protocol)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("%s: query wasn't logged", tc.desc)
+		}
+	}
+
+	for _, params := range []string{"", "name=www.example.com&type=bogus"} {
+		resp, err := http.Get(api.URL + "/resolve?" + params)
+		if err != nil {
+			t.Fatalf("%q: request failed: %s", params, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%q: got status %d, expected %d", params, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package workbench
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/github.com/miekg/dns"
+)
+
+// RawSubnet is a set of records served to clients inside Subnet
+type RawSubnet struct {
+	Subnet  string   `yaml:"subnet" json:"subnet"`
+	Records []string `yaml:"records,omitempty" json:"records"`
+}
+
+type subnet struct {
+	n       *net.IPNet
+	records []dns.RR
+}
+
+type subnets map[string]map[uint16][]subnet
+
+func constructSubnets(rz RawZones) (subnets, error) {
+	s := make(subnets)
+	for host, types := range rz.Subnets {
+		host = dns.Fqdn(host)
+		if _, present := s[host]; !present {
+			s[host] = make(map[uint16][]subnet)
+		}
+
+		for typeStr, rs := range types {
+			rType, present := dns.StringToType[strings.ToUpper(typeStr)]
+			if !present {
+				return nil, fmt.Errorf("Invalid record type")
+			}
+
+			for _, raw := range rs {
+				_, n, err := net.ParseCIDR(raw.Subnet)
+				if err != nil {
+					return nil, fmt.Errorf("Invalid subnet for %s: %v", host, err)
+				}
+				sn := subnet{n: n}
+				for _, presentation := range raw.Records {
+					rr, err := newRecord(host, typeStr, presentation)
+					if err != nil {
+						return nil, err
+					}
+					sn.records = append(sn.records, rr)
+				}
+				s[host][rType] = append(s[host][rType], sn)
+			}
+		}
+	}
+	return s, nil
+}
+
+// lookup returns the records tagged with the most specific subnet containing
+// addr and the prefix length of that subnet
+func (s subnets) lookup(name string, qType uint16, addr net.IP) ([]dns.RR, uint8, bool) {
+	if addr == nil {
+		return nil, 0, false
+	}
+	var best *subnet
+	bestLen := -1
+	for i, sn := range s[name][qType] {
+		if !sn.n.Contains(addr) {
+			continue
+		}
+		if ones, _ := sn.n.Mask.Size(); ones > bestLen {
+			best = &s[name][qType][i]
+			bestLen = ones
+		}
+	}
+	if best == nil {
+		return nil, 0, false
+	}
+	return best.records, uint8(bestLen), true
+}
+
+// requestSubnet returns the client subnet option from the request, if any
+func requestSubnet(r *dns.Msg) (*dns.EDNS0_SUBNET, error) {
+	o := r.IsEdns0()
+	if o == nil {
+		return nil, nil
+	}
+	for _, opt := range o.Option {
+		e, ok := opt.(*dns.EDNS0_SUBNET)
+		if !ok {
+			continue
+		}
+		switch {
+		case e.Family == 1 && e.SourceNetmask <= 32:
+		case e.Family == 2 && e.SourceNetmask <= 128:
+		default:
+			return nil, fmt.Errorf("Invalid client subnet option")
+		}
+		if e.SourceScope != 0 {
+			return nil, fmt.Errorf("Client subnet option has non-zero scope")
+		}
+		return e, nil
+	}
+	return nil, nil
+}
+
+// echoSubnet adds a copy of the client subnet option to the OPT record of m
+// and returns it so that the scope can be set once the answer is known
+func echoSubnet(m *dns.Msg, e *dns.EDNS0_SUBNET) *dns.EDNS0_SUBNET {
+	o := m.IsEdns0()
+	if o == nil {
+		m.SetEdns0(dns.DefaultMsgSize, false)
+		o = m.IsEdns0()
+	}
+	echo := &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        e.Family,
+		SourceNetmask: e.SourceNetmask,
+		Address:       e.Address,
+		DraftOption:   e.DraftOption,
+	}
+	o.Option = append(o.Option, echo)
+	return echo
+}
@@ -0,0 +1,149 @@
+package workbench_test
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/github.com/miekg/dns"
+	"github.com/rolandshoemaker/dns-workbench/workbench"
+	"github.com/rolandshoemaker/dns-workbench/workbench/workbenchtest"
+)
+
+const subnetZones = `
+zones:
+  example.com:
+    www.example.com:
+      a: [192.0.2.1]
+subnets:
+  www.example.com:
+    a:
+      - subnet: 10.0.0.0/8
+        records: [192.0.2.10]
+      - subnet: 10.1.0.0/16
+        records: [192.0.2.11]
+      - subnet: 2001:db8::/32
+        records: [192.0.2.12]
+      - subnet: 127.0.0.2/32
+        records: [192.0.2.13]
+`
+
+// subnetQuery returns a query for www.example.com A with a client subnet
+// option for addr/netmask, scope should be 0 for a valid option
+func subnetQuery(addr string, netmask, scope uint8) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion("www.example.com.", dns.TypeA)
+	m.SetEdns0(dns.DefaultMsgSize, false)
+	e := &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        1,
+		SourceNetmask: netmask,
+		SourceScope:   scope,
+		Address:       net.ParseIP(addr),
+	}
+	if e.Address.To4() == nil {
+		e.Family = 2
+	}
+	o := m.IsEdns0()
+	o.Option = append(o.Option, e)
+	return m
+}
+
+// responseSubnet returns the client subnet option from the OPT record of m
+func responseSubnet(m *dns.Msg) *dns.EDNS0_SUBNET {
+	o := m.IsEdns0()
+	if o == nil {
+		return nil
+	}
+	for _, opt := range o.Option {
+		if e, ok := opt.(*dns.EDNS0_SUBNET); ok {
+			return e
+		}
+	}
+	return nil
+}
+
+func TestClientSubnet(t *testing.T) {
+	s := workbenchtest.NewServerWithConfig(t, subnetZones, workbench.Config{
+		Listeners: []workbench.Listener{{Addr: "127.0.0.1:0"}},
+	})
+	// queries come from 127.0.0.2, which has a subnet of its own, so the
+	// option has to be used rather than the source address
+	for _, tc := range []struct {
+		desc    string
+		addr    string
+		netmask uint8
+		want    string
+		scope   uint8
+	}{
+		{"subnet", "10.2.3.0", 24, "192.0.2.10", 8},
+		{"most specific subnet", "10.1.3.0", 24, "192.0.2.11", 16},
+		{"IPv6 subnet", "2001:db8:1::", 48, "192.0.2.12", 32},
+		{"no matching subnet", "198.51.100.0", 24, "192.0.2.1", 0},
+	} {
+		for _, network := range []string{"udp", "tcp"} {
+			resp := exchange(t, network, s.Addr(), "127.0.0.2", subnetQuery(tc.addr, tc.netmask, 0), nil)
+			if got := values(resp.Answer); !reflect.DeepEqual(got, []string{tc.want}) {
+				t.Errorf("%s over %s: got %q, expected %s", tc.desc, network, got, tc.want)
+			}
+			e := responseSubnet(resp)
+			if e == nil {
+				t.Errorf("%s over %s: client subnet option wasn't echoed", tc.desc, network)
+				continue
+			}
+			if !e.Address.Equal(net.ParseIP(tc.addr)) || e.SourceNetmask != tc.netmask || e.SourceScope != tc.scope {
+				t.Errorf("%s over %s: echoed %s/%d scope %d, expected %s/%d scope %d", tc.desc, network,
+					e.Address, e.SourceNetmask, e.SourceScope, tc.addr, tc.netmask, tc.scope)
+			}
+		}
+	}
+}
+
+func TestClientSubnetSourceAddress(t *testing.T) {
+	s := workbenchtest.NewServer(t, subnetZones)
+	for _, tc := range []struct {
+		from string
+		want string
+	}{
+		{"127.0.0.2", "192.0.2.13"},
+		{"127.0.0.3", "192.0.2.1"},
+	} {
+		m := new(dns.Msg)
+		m.SetQuestion("www.example.com.", dns.TypeA)
+		resp := exchange(t, "udp", s.Addr(), tc.from, m, nil)
+		if got := values(resp.Answer); !reflect.DeepEqual(got, []string{tc.want}) {
+			t.Errorf("From %s: got %q, expected %s", tc.from, got, tc.want)
+		}
+		if e := responseSubnet(resp); e != nil {
+			t.Errorf("From %s: got a client subnet option without sending one", tc.from)
+		}
+	}
+}
+
+func TestClientSubnetInvalid(t *testing.T) {
+	s := workbenchtest.NewServer(t, subnetZones)
+	for _, tc := range []struct {
+		desc string
+		m    *dns.Msg
+	}{
+		{"non-zero scope", subnetQuery("10.0.0.0", 8, 8)},
+		// options the client library refuses to pack
+		{"netmask too long", rawSubnetQuery([]byte{0, 1, 33, 0, 10, 0, 0, 0})},
+		{"unknown family", rawSubnetQuery([]byte{0, 3, 0, 0})},
+	} {
+		resp := exchange(t, "udp", s.Addr(), "", tc.m, nil)
+		if resp.Rcode != dns.RcodeFormatError {
+			t.Errorf("%s: got %s, expected FORMERR", tc.desc, dns.RcodeToString[resp.Rcode])
+		}
+	}
+}
+
+// rawSubnetQuery returns a query with a client subnet option holding data
+func rawSubnetQuery(data []byte) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion("www.example.com.", dns.TypeA)
+	m.SetEdns0(dns.DefaultMsgSize, false)
+	o := m.IsEdns0()
+	o.Option = append(o.Option, &dns.EDNS0_LOCAL{Code: dns.EDNS0SUBNET, Data: data})
+	return m
+}
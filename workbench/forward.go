@@ -0,0 +1,118 @@
+package workbench
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/github.com/miekg/dns"
+)
+
+// forwardedQuery is a query that couldn't be answered from the zones and
+// should be sent to upstreams, or resolved iteratively if there are none.
+// reply is the response prepared for the client and w the writer it should be
+// sent with.
+type forwardedQuery struct {
+	upstreams []string
+	reply     *dns.Msg
+	w         dns.ResponseWriter
+}
+
+// parseForwarders returns the upstream resolvers keyed by the lower case
+// fully qualified suffix they are used for, upstreams without a port use 53
+func parseForwarders(forwarders map[string][]string) (map[string][]string, error) {
+	parsed := make(map[string][]string)
+	for suffix, upstreams := range forwarders {
+		suffix = strings.ToLower(dns.Fqdn(suffix))
+		for _, u := range upstreams {
+			if _, _, err := net.SplitHostPort(u); err != nil {
+				if net.ParseIP(strings.Trim(u, "[]")) == nil {
+					return nil, fmt.Errorf("Invalid upstream address %s", u)
+				}
+				u = net.JoinHostPort(strings.Trim(u, "[]"), "53")
+			}
+			parsed[suffix] = append(parsed[suffix], u)
+		}
+	}
+	return parsed, nil
+}
+
+// upstreams returns the upstream resolvers for the longest suffix of name
+// that has any, or nil if it shouldn't be forwarded
+func (wb *Workbench) upstreams(name string) []string {
+	if len(wb.forwarders) == 0 {
+		return nil
+	}
+	name = strings.ToLower(name)
+	for off, end := 0, false; !end; off, end = dns.NextLabel(name, off) {
+		if upstreams, present := wb.forwarders[name[off:]]; present {
+			return upstreams
+		}
+	}
+	return wb.forwarders["."]
+}
+
+// forward answers r using the response from the first upstream in fq that
+// replies, or the resolver, if none do the client gets SERVFAIL. It returns
+// the upstream that answered.
+func (wb *Workbench) forward(r *dns.Msg, fq *forwardedQuery) string {
+	req := new(dns.Msg)
+	req.Id = dns.Id()
+	req.RecursionDesired = r.RecursionDesired
+	req.CheckingDisabled = r.CheckingDisabled
+	req.Question = r.Question
+	if opt := r.IsEdns0(); opt != nil {
+		req.Extra = append(req.Extra, opt)
+	}
+
+	w, m := fq.w, fq.reply
+	var resp *dns.Msg
+	var upstream string
+	var err error
+	if fq.upstreams != nil {
+		resp, upstream, err = exchange(req, fq.upstreams)
+	} else {
+		resp, err = wb.resolver.Resolve(r.Question[0].Name, r.Question[0].Qtype)
+		upstream = "iterative"
+	}
+	if err != nil {
+		wb.l.Printf("Failed to forward query for %s: %s\n", r.Question[0].Name, err)
+		m.Rcode = dns.RcodeServerFailure
+		w.WriteMsg(m)
+		return ""
+	}
+	m.Rcode = resp.Rcode
+	m.RecursionAvailable = resp.RecursionAvailable
+	m.AuthenticatedData = resp.AuthenticatedData
+	m.Answer = resp.Answer
+	m.Ns = resp.Ns
+	extra := []dns.RR{}
+	for _, rr := range resp.Extra {
+		if t := rr.Header().Rrtype; t != dns.TypeOPT && t != dns.TypeTSIG {
+			extra = append(extra, rr)
+		}
+	}
+	// keep our own OPT and TSIG records at the end
+	m.Extra = append(extra, m.Extra...)
+	w.WriteMsg(m)
+	return upstream
+}
+
+// exchange sends req to each of upstreams in turn until one answers,
+// retrying over TCP if the response is truncated
+func exchange(req *dns.Msg, upstreams []string) (*dns.Msg, string, error) {
+	var err error
+	for _, u := range upstreams {
+		var resp *dns.Msg
+		c := new(dns.Client)
+		resp, _, err = c.Exchange(req, u)
+		if err == nil && resp.Truncated {
+			c.Net = "tcp"
+			resp, _, err = c.Exchange(req, u)
+		}
+		if err == nil {
+			return resp, u, nil
+		}
+	}
+	return nil, "", err
+}
@@ -0,0 +1,135 @@
+package workbench_test
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/github.com/miekg/dns"
+	"github.com/rolandshoemaker/dns-workbench/workbench"
+)
+
+// upstream starts a UDP resolver that answers every A query with answer and
+// returns its address
+func upstream(t *testing.T, answer string) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	started := make(chan struct{})
+	s := &dns.Server{
+		PacketConn:        pc,
+		NotifyStartedFunc: func() { close(started) },
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			m := new(dns.Msg)
+			m.SetReply(r)
+			m.RecursionAvailable = true
+			if len(r.Question) == 1 && r.Question[0].Qtype == dns.TypeA {
+				rr, err := dns.NewRR(fmt.Sprintf("%s 60 IN A %s", r.Question[0].Name, answer))
+				if err == nil {
+					m.Answer = append(m.Answer, rr)
+				}
+			}
+			w.WriteMsg(m)
+		}),
+	}
+	go s.ActivateAndServe()
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatalf("Upstream didn't start")
+	}
+	t.Cleanup(func() { s.Shutdown() })
+	return pc.LocalAddr().String()
+}
+
+// deadUpstream returns a local UDP address nothing is listening on
+func deadUpstream(t *testing.T) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	defer pc.Close()
+	return pc.LocalAddr().String()
+}
+
+func TestForwarding(t *testing.T) {
+	def := upstream(t, "192.0.2.10")
+	com := upstream(t, "192.0.2.11")
+	corp := upstream(t, "192.0.2.12")
+	dev := upstream(t, "192.0.2.13")
+	dead := deadUpstream(t)
+	logged := make(chan *workbench.QueryEntry, 1)
+	wb := newWorkbench(t, exampleZones, workbench.Config{
+		Forwarders: map[string][]string{
+			".":                {def},
+			"example.com":      {com},
+			"corp.example":     {corp},
+			"Dev.Corp.Example": {dev},
+			"dead.example":     {dead},
+			"fallback.example": {dead, corp},
+		},
+		OnQuery: func(qe *workbench.QueryEntry) { logged <- qe },
+	})
+	defer wb.Stop()
+
+	for _, tc := range []struct {
+		desc     string
+		name     string
+		rcode    int
+		want     []string
+		upstream string
+	}{
+		{"local records", "www.example.com", dns.RcodeSuccess, []string{"192.0.2.1"}, ""},
+		{"zone suffix", "mail.example.com", dns.RcodeSuccess, []string{"192.0.2.11"}, com},
+		{"suffix", "host.corp.example", dns.RcodeSuccess, []string{"192.0.2.12"}, corp},
+		{"longest suffix", "host.dev.corp.example", dns.RcodeSuccess, []string{"192.0.2.13"}, dev},
+		{"suffix itself", "DEV.corp.example", dns.RcodeSuccess, []string{"192.0.2.13"}, dev},
+		// suffixes only match whole labels
+		{"partial label", "xcorp.example", dns.RcodeSuccess, []string{"192.0.2.10"}, def},
+		{"default", "www.example.org", dns.RcodeSuccess, []string{"192.0.2.10"}, def},
+		{"dead upstream", "www.dead.example", dns.RcodeServerFailure, []string{}, ""},
+		{"next upstream", "www.fallback.example", dns.RcodeSuccess, []string{"192.0.2.12"}, corp},
+	} {
+		m := new(dns.Msg)
+		m.SetQuestion(dns.Fqdn(tc.name), dns.TypeA)
+		// dead upstreams take as long as the workbench's own two second
+		// timeout to give up on
+		c := &dns.Client{ReadTimeout: 5 * time.Second}
+		resp, _, err := c.Exchange(m, wb.Addr())
+		if err != nil {
+			t.Fatalf("%s: query failed: %s", tc.desc, err)
+		}
+		if resp.Rcode != tc.rcode {
+			t.Errorf("%s: got %s, expected %s", tc.desc, dns.RcodeToString[resp.Rcode], dns.RcodeToString[tc.rcode])
+		}
+		if got := values(resp.Answer); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %q, expected %q", tc.desc, got, tc.want)
+		}
+		select {
+		case qe := <-logged:
+			if qe.Upstream != tc.upstream {
+				t.Errorf("%s: got upstream %q, expected %q", tc.desc, qe.Upstream, tc.upstream)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s: query wasn't logged", tc.desc)
+		}
+	}
+}
+
+func TestForwardersInvalid(t *testing.T) {
+	rz, err := workbench.ParseYAML([]byte(exampleZones))
+	if err != nil {
+		t.Fatalf("Failed to parse zones: %s", err)
+	}
+	_, err = workbench.New(rz, workbench.Config{
+		Forwarders: map[string][]string{".": {"resolver.example"}},
+	})
+	if err == nil {
+		t.Errorf("Created a workbench with an upstream that isn't an address")
+	}
+}
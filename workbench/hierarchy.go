@@ -0,0 +1,183 @@
+package workbench
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/github.com/miekg/dns"
+)
+
+// rootServer is the name of the nameserver for the generated root zone
+const rootServer = "a.root-servers."
+
+// addrRecords returns the A and AAAA presentation values for addrs
+func addrRecords(addrs []string) (map[string][]string, error) {
+	records := make(map[string][]string)
+	for _, a := range addrs {
+		ip := net.ParseIP(a)
+		if ip == nil {
+			return nil, fmt.Errorf("Invalid nameserver address %s", a)
+		}
+		if ip.To4() != nil {
+			records["a"] = append(records["a"], ip.String())
+		} else {
+			records["aaaa"] = append(records["aaaa"], ip.String())
+		}
+	}
+	return records, nil
+}
+
+// parentZone returns the closest zone in zones that encloses name
+func parentZone(name string, zones map[string]bool) string {
+	for off, end := dns.NextLabel(name, 0); !end; off, end = dns.NextLabel(name, off) {
+		if zones[name[off:]] {
+			return name[off:]
+		}
+	}
+	return "."
+}
+
+// dsRecords returns DS presentation values for the DNSKEY records at the apex
+// of zone, only keys with the SEP flag are used unless there are none
+func dsRecords(zone string, hosts map[string]map[string][]string) ([]string, error) {
+	keys := []*dns.DNSKEY{}
+	for t, values := range hosts[hostKey(hosts, zone)] {
+		if strings.ToLower(t) != "dnskey" {
+			continue
+		}
+		for _, presentation := range values {
+			rr, err := newRecord(zone, t, presentation)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, rr.(*dns.DNSKEY))
+		}
+	}
+	sep := []*dns.DNSKEY{}
+	for _, k := range keys {
+		if k.Flags&dns.SEP != 0 {
+			sep = append(sep, k)
+		}
+	}
+	if len(sep) > 0 {
+		keys = sep
+	}
+
+	ds := []string{}
+	for _, k := range keys {
+		rr := k.ToDS(dns.SHA256)
+		if rr == nil {
+			return nil, fmt.Errorf("Invalid public key %s", k.PublicKey)
+		}
+		ds = append(ds, strings.TrimSpace(strings.TrimPrefix(rr.String(), rr.Hdr.String())))
+	}
+	return ds, nil
+}
+
+// GenerateHierarchy returns rz with a root zone and a zone for each top level
+// domain added, delegating down to every zone in rz so a recursive resolver
+// can use the workbench in place of the real DNS tree. Every nameserver in
+// the hierarchy has the addresses in addrs. If ds is true DS records are
+// added for delegated zones with DNSKEY records at their apex.
+func GenerateHierarchy(rz RawZones, addrs []string, ds bool) (RawZones, error) {
+	glue, err := addrRecords(addrs)
+	if err != nil {
+		return rz, err
+	}
+
+	out := rz
+	out.Zones = make(map[string]map[string]map[string][]string)
+	leaves := make(map[string]bool)
+	for zone, hosts := range rz.Zones {
+		name := strings.ToLower(dns.Fqdn(zone))
+		if name == "." {
+			return rz, fmt.Errorf("Zones already include the root zone")
+		}
+		leaves[name] = true
+		out.Zones[name] = make(map[string]map[string][]string, len(hosts))
+		for host, types := range hosts {
+			out.Zones[name][host] = types
+		}
+	}
+
+	all := make(map[string]bool)
+	for name := range leaves {
+		all[name] = true
+		labels := dns.SplitDomainName(name)
+		tld := dns.Fqdn(labels[len(labels)-1])
+		// a TLD can also be one of the zones being delegated to
+		if !all[tld] && !leaves[tld] {
+			out.Zones[tld] = make(map[string]map[string][]string)
+		}
+		all[tld] = true
+	}
+	out.Zones["."] = map[string]map[string][]string{
+		".":        {"ns": {rootServer}},
+		rootServer: glue,
+	}
+
+	names := []string{}
+	for name := range all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		hosts := out.Zones[name]
+		ns := "ns." + name
+		if !leaves[name] {
+			ns = "a.nic." + name
+		}
+		// keep the addresses of nameservers the zone already defines
+		if hosts[hostKey(hosts, ns)] == nil {
+			hosts[ns] = glue
+		}
+		if apex := hostKey(hosts, name); len(hosts[apex]["ns"]) == 0 {
+			addRecords(hosts, apex, map[string][]string{"ns": {ns}})
+		}
+
+		delegation := map[string][]string{"ns": {ns}}
+		if ds && leaves[name] {
+			records, err := dsRecords(name, rz.Zones[zoneKey(rz.Zones, name)])
+			if err != nil {
+				return rz, fmt.Errorf("Bad DNSKEY for %s: %v", name, err)
+			}
+			if len(records) > 0 {
+				delegation["ds"] = records
+			}
+		}
+		parent := out.Zones[parentZone(name, all)]
+		addRecords(parent, hostKey(parent, name), delegation)
+	}
+	return out, nil
+}
+
+// addRecords sets the records of each type in records for host, without
+// modifying the existing map for host which may be shared with the input
+func addRecords(hosts map[string]map[string][]string, host string, records map[string][]string) {
+	types := make(map[string][]string, len(hosts[host])+len(records))
+	for t, values := range hosts[host] {
+		types[t] = values
+	}
+	for t, values := range records {
+		types[t] = values
+	}
+	hosts[host] = types
+}
+
+// RootHints returns a root hints file pointing resolvers at the root zone
+// generated by GenerateHierarchy
+func RootHints(addrs []string) (string, error) {
+	glue, err := addrRecords(addrs)
+	if err != nil {
+		return "", err
+	}
+	hints := fmt.Sprintf(".\t3600000\tNS\t%s\n", rootServer)
+	for _, t := range []string{"a", "aaaa"} {
+		for _, a := range glue[t] {
+			hints += fmt.Sprintf("%s\t3600000\t%s\t%s\n", rootServer, strings.ToUpper(t), a)
+		}
+	}
+	return hints, nil
+}
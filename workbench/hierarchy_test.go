@@ -0,0 +1,128 @@
+package workbench
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/github.com/miekg/dns"
+)
+
+const (
+	testKSK = "257 3 13 mdsswUyr3DPW132mOi8V9xESWE8jTo0dxCjjnopKl+GqJxpVXckHAeF+KkxLbxILfDLUT0rAK9iUzy1L53eKGQ=="
+	testZSK = "256 3 13 oJMRESz5E4gYzS/q6XDrvU1qMPYIjCWzJaOau8XNEZeqCYKD5ar0IRd8KqXXFJkqmVfRvMGPmM1x8fGAa2XhSA=="
+)
+
+func hierarchyZones() RawZones {
+	return RawZones{Zones: zonesOf(map[string]rawHosts{
+		"example.com": {"example.com": {"a": {"192.0.2.1"}, "dnskey": {testKSK, testZSK}}},
+		"example.org": {"ns.example.org": {"a": {"192.0.2.53"}}},
+		// a TLD that is also a leaf with another leaf below it
+		"test":         {"www.test": {"a": {"192.0.2.2"}}},
+		"example.test": {"example.test": {"a": {"192.0.2.3"}}},
+	})}
+}
+
+// records returns the records of rrType for host in zone of rz, ignoring case
+// and trailing dots in the names
+func records(rz RawZones, zone, host, rrType string) []string {
+	hosts := rz.Zones[zoneKey(rz.Zones, zone)]
+	return hosts[hostKey(hosts, host)][rrType]
+}
+
+func TestGenerateHierarchy(t *testing.T) {
+	key, err := dns.NewRR("example.com. DNSKEY " + testKSK)
+	if err != nil {
+		t.Fatalf("Bad test key: %s", err)
+	}
+	ds := key.(*dns.DNSKEY).ToDS(dns.SHA256)
+	wantDS := strings.TrimSpace(strings.TrimPrefix(ds.String(), ds.Hdr.String()))
+
+	rz := hierarchyZones()
+	out, err := GenerateHierarchy(rz, []string{"127.0.0.1", "::1"}, true)
+	if err != nil {
+		t.Fatalf("GenerateHierarchy failed: %s", err)
+	}
+	glue := []string{"127.0.0.1"}
+	glue6 := []string{"::1"}
+
+	for _, tc := range []struct {
+		zone, host, rrType string
+		want               []string
+	}{
+		// the root delegates to every TLD
+		{".", ".", "ns", []string{rootServer}},
+		{".", rootServer, "a", glue},
+		{".", rootServer, "aaaa", glue6},
+		{".", "com.", "ns", []string{"a.nic.com."}},
+		{".", "org.", "ns", []string{"a.nic.org."}},
+		{".", "test.", "ns", []string{"ns.test."}},
+		// generated TLDs have an apex and nameserver of their own
+		{"com.", "com.", "ns", []string{"a.nic.com."}},
+		{"com.", "a.nic.com.", "a", glue},
+		{"com.", "example.com.", "ns", []string{"ns.example.com."}},
+		{"com.", "example.com.", "ds", []string{wantDS}},
+		{"org.", "example.org.", "ns", []string{"ns.example.org."}},
+		{"org.", "example.org.", "ds", nil},
+		// leaf zones keep their records and get nameservers
+		{"example.com.", "example.com.", "a", []string{"192.0.2.1"}},
+		{"example.com.", "example.com.", "ns", []string{"ns.example.com."}},
+		{"example.com.", "ns.example.com.", "a", glue},
+		{"example.org.", "ns.example.org.", "a", []string{"192.0.2.53"}},
+		// a leaf TLD keeps its records and delegates to the leaf below it
+		{"test.", "www.test.", "a", []string{"192.0.2.2"}},
+		{"test.", "test.", "ns", []string{"ns.test."}},
+		{"test.", "ns.test.", "a", glue},
+		{"test.", "example.test.", "ns", []string{"ns.example.test."}},
+		{"example.test.", "example.test.", "a", []string{"192.0.2.3"}},
+	} {
+		if got := records(out, tc.zone, tc.host, tc.rrType); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s %s in zone %s = %q, expected %q", tc.host, strings.ToUpper(tc.rrType), tc.zone, got, tc.want)
+		}
+	}
+
+	if !reflect.DeepEqual(rz, hierarchyZones()) {
+		t.Errorf("GenerateHierarchy modified the input zones")
+	}
+}
+
+func TestGenerateHierarchyLeafTLD(t *testing.T) {
+	// which of test. and example.test. is seen first depends on map order
+	for i := 0; i < 100; i++ {
+		out, err := GenerateHierarchy(hierarchyZones(), []string{"127.0.0.1"}, false)
+		if err != nil {
+			t.Fatalf("GenerateHierarchy failed: %s", err)
+		}
+		if got := records(out, "test.", "www.test.", "a"); len(got) != 1 {
+			t.Fatalf("www.test. A = %q after %d runs, expected it to be kept", got, i+1)
+		}
+	}
+}
+
+func TestGenerateHierarchyErrors(t *testing.T) {
+	for _, tc := range []struct {
+		desc  string
+		rz    RawZones
+		addrs []string
+	}{
+		{
+			desc:  "bad nameserver address",
+			rz:    hierarchyZones(),
+			addrs: []string{"localhost"},
+		},
+		{
+			desc:  "root zone",
+			rz:    RawZones{Zones: zonesOf(map[string]rawHosts{".": {}})},
+			addrs: []string{"127.0.0.1"},
+		},
+		{
+			desc:  "undigestable DNSKEY",
+			rz:    RawZones{Zones: zonesOf(map[string]rawHosts{"example.com": {"example.com": {"dnskey": {"257 3 8 !!!notbase64!!!"}}}})},
+			addrs: []string{"127.0.0.1"},
+		},
+	} {
+		if _, err := GenerateHierarchy(tc.rz, tc.addrs, true); err == nil {
+			t.Errorf("%s: GenerateHierarchy succeeded, expected an error", tc.desc)
+		}
+	}
+}
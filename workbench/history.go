@@ -0,0 +1,200 @@
+package workbench
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/github.com/miekg/dns"
+)
+
+// queryHistory is a ring buffer holding the most recently received queries
+type queryHistory struct {
+	mu      sync.Mutex
+	entries []*QueryEntry
+	next    int
+	full    bool
+	// closed and replaced every time a query is added
+	added chan struct{}
+}
+
+func newQueryHistory(size int) *queryHistory {
+	return &queryHistory{entries: make([]*QueryEntry, size), added: make(chan struct{})}
+}
+
+func (qh *queryHistory) add(qe *QueryEntry) {
+	qh.mu.Lock()
+	defer qh.mu.Unlock()
+	qh.entries[qh.next] = qe
+	qh.next = (qh.next + 1) % len(qh.entries)
+	if qh.next == 0 {
+		qh.full = true
+	}
+	close(qh.added)
+	qh.added = make(chan struct{})
+}
+
+func (qh *queryHistory) reset() {
+	qh.mu.Lock()
+	defer qh.mu.Unlock()
+	qh.entries = make([]*QueryEntry, len(qh.entries))
+	qh.next = 0
+	qh.full = false
+}
+
+type queryFilter struct {
+	name   string
+	qType  string
+	client string
+	since  time.Time
+	until  time.Time
+}
+
+func parseQueryFilter(r *http.Request) (queryFilter, error) {
+	qf := queryFilter{
+		qType:  strings.ToUpper(r.FormValue("type")),
+		client: r.FormValue("client"),
+	}
+	if name := r.FormValue("name"); name != "" {
+		qf.name = strings.ToLower(dns.Fqdn(name))
+	}
+	var err error
+	if since := r.FormValue("since"); since != "" {
+		qf.since, err = time.Parse(time.RFC3339Nano, since)
+		if err != nil {
+			return qf, err
+		}
+	}
+	if until := r.FormValue("until"); until != "" {
+		qf.until, err = time.Parse(time.RFC3339Nano, until)
+		if err != nil {
+			return qf, err
+		}
+	}
+	return qf, nil
+}
+
+func (qf queryFilter) matches(qe *QueryEntry) bool {
+	if qf.name != "" && strings.ToLower(qe.Name) != qf.name {
+		return false
+	}
+	if qf.qType != "" && qe.Type != qf.qType {
+		return false
+	}
+	if qf.client != "" && qe.Client != qf.client {
+		if host, _, err := net.SplitHostPort(qe.Client); err != nil || host != qf.client {
+			return false
+		}
+	}
+	if !qf.since.IsZero() && qe.Time.Before(qf.since) {
+		return false
+	}
+	if !qf.until.IsZero() && qe.Time.After(qf.until) {
+		return false
+	}
+	return true
+}
+
+// list returns the queries matching qf, oldest first, and a channel that is
+// closed when the next query is added
+func (qh *queryHistory) list(qf queryFilter) ([]*QueryEntry, chan struct{}) {
+	qh.mu.Lock()
+	defer qh.mu.Unlock()
+	matched := []*QueryEntry{}
+	start := 0
+	if qh.full {
+		start = qh.next
+	}
+	for i := 0; i < len(qh.entries); i++ {
+		qe := qh.entries[(start+i)%len(qh.entries)]
+		if qe != nil && qf.matches(qe) {
+			matched = append(matched, qe)
+		}
+	}
+	return matched, qh.added
+}
+
+// wait blocks until at least count queries matching qf have been received or
+// the timeout expires
+func (qh *queryHistory) wait(qf queryFilter, count int, timeout time.Duration) ([]*QueryEntry, bool) {
+	expired := time.After(timeout)
+	for {
+		matched, added := qh.list(qf)
+		if len(matched) >= count {
+			return matched, true
+		}
+		select {
+		case <-added:
+		case <-expired:
+			return matched, false
+		}
+	}
+}
+
+func sendQueries(status int, entries []*QueryEntry, w http.ResponseWriter) {
+	body, err := json.Marshal(entries)
+	if err != nil {
+		sendError(err.Error(), w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func (wb *Workbench) apiQueries(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		qf, err := parseQueryFilter(r)
+		if err != nil {
+			sendError(err.Error(), w)
+			return
+		}
+		matched, _ := wb.qh.list(qf)
+		sendQueries(http.StatusOK, matched, w)
+	case "DELETE":
+		wb.qh.reset()
+	default:
+		sendError("Method not supported", w)
+	}
+}
+
+func (wb *Workbench) apiQueriesWait(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		sendError("Method not supported", w)
+		return
+	}
+
+	qf, err := parseQueryFilter(r)
+	if err != nil {
+		sendError(err.Error(), w)
+		return
+	}
+	count := 1
+	if c := r.FormValue("count"); c != "" {
+		count, err = strconv.Atoi(c)
+		if err != nil {
+			sendError(err.Error(), w)
+			return
+		}
+	}
+	timeout := time.Second * 10
+	if t := r.FormValue("timeout"); t != "" {
+		timeout, err = time.ParseDuration(t)
+		if err != nil {
+			sendError(err.Error(), w)
+			return
+		}
+	}
+
+	matched, ok := wb.qh.wait(qf, count, timeout)
+	if !ok {
+		sendQueries(http.StatusRequestTimeout, matched, w)
+		return
+	}
+	sendQueries(http.StatusOK, matched, w)
+}
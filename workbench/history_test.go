@@ -0,0 +1,250 @@
+package workbench_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/github.com/miekg/dns"
+	"github.com/rolandshoemaker/dns-workbench/workbench"
+)
+
+// historyServer is a workbench keeping size queries in its history and its
+// API, queries are sent using query which returns once they're recorded
+type historyServer struct {
+	wb     *workbench.Workbench
+	api    *httptest.Server
+	logged chan *workbench.QueryEntry
+}
+
+func newHistoryServer(t *testing.T, size int) *historyServer {
+	hs := &historyServer{logged: make(chan *workbench.QueryEntry, 10)}
+	hs.wb = newWorkbench(t, exampleZones, workbench.Config{
+		QueryHistory: size,
+		OnQuery:      func(qe *workbench.QueryEntry) { hs.logged <- qe },
+	})
+	hs.api = httptest.NewServer(hs.wb.APIHandler())
+	t.Cleanup(func() {
+		hs.api.Close()
+		hs.wb.Stop()
+	})
+	return hs
+}
+
+func (hs *historyServer) query(t *testing.T, from, name string, qType uint16) *workbench.QueryEntry {
+	t.Helper()
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), qType)
+	exchange(t, "udp", hs.wb.Addr(), from, m, nil)
+	select {
+	case qe := <-hs.logged:
+		return qe
+	case <-time.After(time.Second):
+		t.Fatalf("Query for %s wasn't recorded", name)
+	}
+	return nil
+}
+
+// get requests path with the query parameters in params and returns the
+// status code and the names of the queries in the response
+func (hs *historyServer) get(t *testing.T, path string, params url.Values) (int, []string) {
+	t.Helper()
+	resp, err := http.Get(hs.api.URL + path + "?" + params.Encode())
+	if err != nil {
+		t.Fatalf("Request for %s failed: %s", path, err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read response for %s: %s", path, err)
+	}
+	if resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusNotFound {
+		return resp.StatusCode, nil
+	}
+	var entries []workbench.QueryEntry
+	err = json.Unmarshal(body, &entries)
+	if err != nil {
+		t.Fatalf("Failed to parse response for %s: %s: %q", path, err, body)
+	}
+	names := []string{}
+	for _, qe := range entries {
+		names = append(names, qe.Name+" "+qe.Type)
+	}
+	return resp.StatusCode, names
+}
+
+func TestQueryHistory(t *testing.T) {
+	hs := newHistoryServer(t, 4)
+	first := hs.query(t, "127.0.0.1", "www.example.com", dns.TypeA)
+	hs.query(t, "127.0.0.2", "www.example.com", dns.TypeAAAA)
+	hs.query(t, "127.0.0.2", "missing.example.com", dns.TypeA)
+	past := first.Time.Add(-time.Hour).Format(time.RFC3339Nano)
+	future := time.Now().Add(time.Hour).Format(time.RFC3339Nano)
+
+	for _, tc := range []struct {
+		desc   string
+		params url.Values
+		status int
+		want   []string
+	}{
+		{
+			desc:   "everything",
+			status: http.StatusOK,
+			want:   []string{"www.example.com. A", "www.example.com. AAAA", "missing.example.com. A"},
+		},
+		{
+			desc:   "name",
+			params: url.Values{"name": {"WWW.example.com"}},
+			status: http.StatusOK,
+			want:   []string{"www.example.com. A", "www.example.com. AAAA"},
+		},
+		{
+			desc:   "type",
+			params: url.Values{"type": {"aaaa"}},
+			status: http.StatusOK,
+			want:   []string{"www.example.com. AAAA"},
+		},
+		{
+			desc:   "client address",
+			params: url.Values{"client": {"127.0.0.2"}},
+			status: http.StatusOK,
+			want:   []string{"www.example.com. AAAA", "missing.example.com. A"},
+		},
+		{
+			desc:   "client address and port",
+			params: url.Values{"client": {first.Client}},
+			status: http.StatusOK,
+			want:   []string{"www.example.com. A"},
+		},
+		{
+			desc:   "every filter",
+			params: url.Values{"name": {"www.example.com."}, "type": {"A"}, "client": {"127.0.0.1"}, "since": {past}, "until": {future}},
+			status: http.StatusOK,
+			want:   []string{"www.example.com. A"},
+		},
+		{
+			desc:   "since",
+			params: url.Values{"since": {future}},
+			status: http.StatusOK,
+			want:   []string{},
+		},
+		{
+			desc:   "until",
+			params: url.Values{"until": {past}},
+			status: http.StatusOK,
+			want:   []string{},
+		},
+		{
+			desc:   "bad time",
+			params: url.Values{"since": {"yesterday"}},
+			status: http.StatusBadRequest,
+		},
+	} {
+		status, got := hs.get(t, "/api/queries", tc.params)
+		if status != tc.status || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %d %q, expected %d %q", tc.desc, status, got, tc.status, tc.want)
+		}
+	}
+
+	// the oldest queries are dropped once the history is full
+	hs.query(t, "127.0.0.1", "a.example.com", dns.TypeA)
+	hs.query(t, "127.0.0.1", "b.example.com", dns.TypeA)
+	want := []string{"www.example.com. AAAA", "missing.example.com. A", "a.example.com. A", "b.example.com. A"}
+	if _, got := hs.get(t, "/api/queries", nil); !reflect.DeepEqual(got, want) {
+		t.Errorf("Full history: got %q, expected %q", got, want)
+	}
+
+	req, err := http.NewRequest("DELETE", hs.api.URL+"/api/queries", nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %s", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("DELETE failed: %s", err)
+	}
+	resp.Body.Close()
+	if _, got := hs.get(t, "/api/queries", nil); len(got) != 0 {
+		t.Errorf("History after DELETE: got %q, expected nothing", got)
+	}
+}
+
+func TestQueryHistoryWait(t *testing.T) {
+	hs := newHistoryServer(t, 10)
+	hs.query(t, "", "www.example.com", dns.TypeA)
+
+	type result struct {
+		status int
+		names  []string
+	}
+	done := make(chan result)
+	go func() {
+		status, names := hs.get(t, "/api/queries/wait", url.Values{"name": {"www.example.com"}, "count": {"2"}, "timeout": {"5s"}})
+		done <- result{status, names}
+	}()
+	select {
+	case r := <-done:
+		t.Fatalf("Wait returned before the second query was sent: %d %q", r.status, r.names)
+	case <-time.After(100 * time.Millisecond):
+	}
+	hs.query(t, "", "other.example.com", dns.TypeA)
+	hs.query(t, "", "www.example.com", dns.TypeAAAA)
+	select {
+	case r := <-done:
+		want := []string{"www.example.com. A", "www.example.com. AAAA"}
+		if r.status != http.StatusOK || !reflect.DeepEqual(r.names, want) {
+			t.Errorf("Wait: got %d %q, expected 200 %q", r.status, r.names, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Wait didn't return once the second query was received")
+	}
+
+	for _, tc := range []struct {
+		desc   string
+		params url.Values
+		status int
+		want   []string
+	}{
+		{
+			desc:   "already received",
+			params: url.Values{"name": {"other.example.com"}},
+			status: http.StatusOK,
+			want:   []string{"other.example.com. A"},
+		},
+		{
+			// the queries matched so far are still returned
+			desc:   "timeout",
+			params: url.Values{"name": {"other.example.com"}, "count": {"2"}, "timeout": {"50ms"}},
+			status: http.StatusRequestTimeout,
+			want:   []string{"other.example.com. A"},
+		},
+		{
+			desc:   "bad count",
+			params: url.Values{"count": {"two"}},
+			status: http.StatusBadRequest,
+		},
+		{
+			desc:   "bad timeout",
+			params: url.Values{"timeout": {"soon"}},
+			status: http.StatusBadRequest,
+		},
+	} {
+		status, got := hs.get(t, "/api/queries/wait", tc.params)
+		if status != tc.status || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %d %q, expected %d %q", tc.desc, status, got, tc.status, tc.want)
+		}
+	}
+}
+
+func TestQueryHistoryDisabled(t *testing.T) {
+	hs := newHistoryServer(t, 0)
+	for _, path := range []string{"/api/queries", "/api/queries/wait"} {
+		if status, _ := hs.get(t, path, nil); status != http.StatusNotFound {
+			t.Errorf("%s: got %d without a history, expected 404", path, status)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package workbench_test
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/github.com/miekg/dns"
+	"github.com/rolandshoemaker/dns-workbench/workbench"
+	"github.com/rolandshoemaker/dns-workbench/workbench/workbenchtest"
+)
+
+const listenerZones = `
+views:
+  - name: udp-view
+    match:
+      clients: [192.0.2.0/24]
+    zones:
+      example.com:
+        www.example.com:
+          a: [192.0.2.2]
+  - name: tcp-view
+    match:
+      clients: [192.0.2.0/24]
+    zones:
+      example.com:
+        www.example.com:
+          a: [192.0.2.3]
+zones:
+  example.com:
+    www.example.com:
+      a: [192.0.2.1]
+`
+
+func TestListeners(t *testing.T) {
+	shared := freeAddr(t)
+	_, port, err := net.SplitHostPort(shared)
+	if err != nil {
+		t.Fatalf("Bad address %s: %s", shared, err)
+	}
+	other := net.JoinHostPort("127.0.0.2", port)
+	s := workbenchtest.NewServerWithConfig(t, listenerZones, workbench.Config{
+		Listeners: []workbench.Listener{
+			// every network uses the same free port
+			{Addr: "127.0.0.1:0", View: "udp-view"},
+			// the same port split between views by network
+			{Addr: shared, Nets: []string{"udp"}, View: "udp-view"},
+			{Addr: shared, Nets: []string{"tcp"}, View: "tcp-view"},
+			// the same port on another address
+			{Addr: other},
+		},
+	})
+	addrs := s.Addrs()
+	if len(addrs) != 3 || addrs[1] != shared || addrs[2] != other {
+		t.Fatalf("Got listener addresses %q, expected a free port, %s and %s", addrs, shared, other)
+	}
+
+	for _, tc := range []struct {
+		desc    string
+		addr    string
+		network string
+		want    string
+	}{
+		{"free port over UDP", addrs[0], "udp", "192.0.2.2"},
+		{"free port over TCP", addrs[0], "tcp", "192.0.2.2"},
+		{"shared port over UDP", shared, "udp", "192.0.2.2"},
+		{"shared port over TCP", shared, "tcp", "192.0.2.3"},
+		{"other address over UDP", other, "udp", "192.0.2.1"},
+		{"other address over TCP", other, "tcp", "192.0.2.1"},
+	} {
+		m := new(dns.Msg)
+		m.SetQuestion("www.example.com.", dns.TypeA)
+		resp := exchange(t, tc.network, tc.addr, "", m, nil)
+		if got := values(resp.Answer); !reflect.DeepEqual(got, []string{tc.want}) {
+			t.Errorf("%s: got %q, expected %s", tc.desc, got, tc.want)
+		}
+	}
+}
+
+func TestListenerConflict(t *testing.T) {
+	shared := freeAddr(t)
+	rz, err := workbench.ParseYAML([]byte(exampleZones))
+	if err != nil {
+		t.Fatalf("Failed to parse zones: %s", err)
+	}
+	wb, err := workbench.New(rz, workbench.Config{
+		Listeners: []workbench.Listener{
+			{Addr: shared},
+			{Addr: shared, Nets: []string{"udp"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create workbench: %s", err)
+	}
+	if err := wb.Start(); err == nil {
+		wb.Stop()
+		t.Fatalf("Started two UDP listeners on %s", shared)
+	}
+
+	// the listeners that did start are closed again
+	for _, network := range []string{"udp", "tcp"} {
+		var err error
+		if network == "udp" {
+			var pc net.PacketConn
+			pc, err = net.ListenPacket(network, shared)
+			if err == nil {
+				pc.Close()
+			}
+		} else {
+			var ln net.Listener
+			ln, err = net.Listen(network, shared)
+			if err == nil {
+				ln.Close()
+			}
+		}
+		if err != nil {
+			t.Errorf("%s %s is still in use after Start failed: %s", network, shared, err)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package workbench
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/github.com/miekg/dns"
+)
+
+func isYAML(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yml" || ext == ".yaml"
+}
+
+func isMaster(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".zone" || ext == ".db"
+}
+
+// LoadMaster reads a zone file in the standard master format. The zone name
+// is taken from the SOA record, whose contents and TTLs are otherwise ignored
+// since the workbench generates its own.
+func LoadMaster(path string) (RawZones, error) {
+	rz := RawZones{}
+	f, err := os.Open(path)
+	if err != nil {
+		return rz, err
+	}
+	defer f.Close()
+
+	// use the file name as the origin for files without an $ORIGIN
+	origin := dns.Fqdn(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+	zone := ""
+	hosts := make(map[string]map[string][]string)
+	for t := range dns.ParseZone(f, origin, path) {
+		if t.Error != nil {
+			return rz, t.Error
+		}
+		hdr := t.RR.Header()
+		if hdr.Rrtype == dns.TypeSOA {
+			if zone != "" {
+				return rz, fmt.Errorf("Multiple SOA records")
+			}
+			zone = hdr.Name
+			continue
+		}
+		typeStr := strings.ToLower(dns.TypeToString[hdr.Rrtype])
+		if _, present := hosts[hdr.Name]; !present {
+			hosts[hdr.Name] = make(map[string][]string)
+		}
+		presentation := strings.TrimSpace(strings.TrimPrefix(t.RR.String(), hdr.String()))
+		hosts[hdr.Name][typeStr] = append(hosts[hdr.Name][typeStr], presentation)
+	}
+	if zone == "" {
+		return rz, fmt.Errorf("No SOA record")
+	}
+	rz.Zones = map[string]map[string]map[string][]string{zone: hosts}
+	return rz, nil
+}
+
+// loadFile loads a zone file, using the master format for files ending in
+// .zone or .db and YAML for everything else
+func loadFile(path string) (RawZones, error) {
+	if isMaster(path) {
+		return LoadMaster(path)
+	}
+	return LoadYAML(path)
+}
+
+// dirFiles returns the zone files in dir, files that don't end in .yml,
+// .yaml, .zone or .db are ignored
+func dirFiles(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	for _, e := range entries {
+		if e.IsDir() || !(isYAML(e.Name()) || isMaster(e.Name())) {
+			continue
+		}
+		files = append(files, filepath.Join(dir, e.Name()))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// LoadFiles loads and merges the zone files in files and the directories in
+// dirs, returning an error if two of them define the same zone, script,
+// ordering policy, subnet, view or policy zone
+func LoadFiles(files []string, dirs []string) (RawZones, error) {
+	all := append([]string{}, files...)
+	for _, dir := range dirs {
+		found, err := dirFiles(dir)
+		if err != nil {
+			return RawZones{}, err
+		}
+		all = append(all, found...)
+	}
+
+	m := newMerger()
+	for _, path := range all {
+		rz, err := loadFile(path)
+		if err != nil {
+			return RawZones{}, fmt.Errorf("%s: %v", path, err)
+		}
+		err = m.add(path, rz)
+		if err != nil {
+			return RawZones{}, err
+		}
+	}
+	return m.rz, nil
+}
+
+type merger struct {
+	rz      RawZones
+	sources map[string]string
+}
+
+func newMerger() *merger {
+	return &merger{
+		rz: RawZones{
+			Zones:    make(map[string]map[string]map[string][]string),
+			Scripts:  make(map[string]map[string]RawScript),
+			Ordering: make(map[string]RawZoneOrder),
+			Subnets:  make(map[string]map[string][]RawSubnet),
+		},
+		sources: make(map[string]string),
+	}
+}
+
+// claim records that path defines what, returning an error if another file
+// already does
+func (m *merger) claim(path, what string) error {
+	if other, present := m.sources[what]; present {
+		return fmt.Errorf("%s is defined in both %s and %s", what, other, path)
+	}
+	m.sources[what] = path
+	return nil
+}
+
+func (m *merger) add(path string, rz RawZones) error {
+	for zone, hosts := range rz.Zones {
+		err := m.claim(path, fmt.Sprintf("zone %s", strings.ToLower(dns.Fqdn(zone))))
+		if err != nil {
+			return err
+		}
+		m.rz.Zones[zone] = hosts
+	}
+	for host, types := range rz.Scripts {
+		for t, s := range types {
+			err := m.claim(path, fmt.Sprintf("script for %s %s", strings.ToLower(dns.Fqdn(host)), strings.ToUpper(t)))
+			if err != nil {
+				return err
+			}
+			if _, present := m.rz.Scripts[host]; !present {
+				m.rz.Scripts[host] = make(map[string]RawScript)
+			}
+			m.rz.Scripts[host][t] = s
+		}
+	}
+	for zone, o := range rz.Ordering {
+		err := m.claim(path, fmt.Sprintf("ordering for zone %s", strings.ToLower(dns.Fqdn(zone))))
+		if err != nil {
+			return err
+		}
+		m.rz.Ordering[zone] = o
+	}
+	for host, types := range rz.Subnets {
+		for t, s := range types {
+			err := m.claim(path, fmt.Sprintf("subnets for %s %s", strings.ToLower(dns.Fqdn(host)), strings.ToUpper(t)))
+			if err != nil {
+				return err
+			}
+			if _, present := m.rz.Subnets[host]; !present {
+				m.rz.Subnets[host] = make(map[string][]RawSubnet)
+			}
+			m.rz.Subnets[host][t] = s
+		}
+	}
+	for _, p := range rz.Policies {
+		err := m.claim(path, fmt.Sprintf("policy zone %s", strings.ToLower(dns.Fqdn(p))))
+		if err != nil {
+			return err
+		}
+		m.rz.Policies = append(m.rz.Policies, p)
+	}
+	for _, v := range rz.Views {
+		err := m.claim(path, fmt.Sprintf("view %s", v.Name))
+		if err != nil {
+			return err
+		}
+		m.rz.Views = append(m.rz.Views, v)
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package workbench_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rolandshoemaker/dns-workbench/workbench"
+)
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "load")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestLoadMaster(t *testing.T) {
+	dir := tempDir(t)
+	for _, tc := range []struct {
+		desc     string
+		file     string
+		contents string
+		want     map[string]map[string]map[string][]string
+	}{
+		{
+			desc: "origin",
+			file: "zone.db",
+			contents: `$ORIGIN example.com.
+$TTL 3600
+@ IN SOA ns1 hostmaster 1 7200 900 1209600 300
+@ IN MX 10 mail
+www IN A 192.0.2.1
+www 60 IN A 192.0.2.2
+mail.example.com. IN AAAA 2001:db8::1
+txt IN TXT "hello world" "again"
+`,
+			want: map[string]map[string]map[string][]string{
+				"example.com.": {
+					"example.com.":      {"mx": {"10 mail.example.com."}},
+					"www.example.com.":  {"a": {"192.0.2.1", "192.0.2.2"}},
+					"mail.example.com.": {"aaaa": {"2001:db8::1"}},
+					"txt.example.com.":  {"txt": {`"hello world" "again"`}},
+				},
+			},
+		},
+		{
+			// the file name is the origin when there isn't an $ORIGIN
+			desc: "file name origin",
+			file: "example.org.zone",
+			contents: `@ 300 IN SOA ns1 hostmaster 1 7200 900 1209600 300
+www 300 IN CNAME @
+`,
+			want: map[string]map[string]map[string][]string{
+				"example.org.": {
+					"www.example.org.": {"cname": {"example.org."}},
+				},
+			},
+		},
+	} {
+		path := filepath.Join(dir, tc.file)
+		writeFile(t, path, tc.contents)
+		rz, err := workbench.LoadMaster(path)
+		if err != nil {
+			t.Errorf("%s: failed to load: %s", tc.desc, err)
+			continue
+		}
+		if !reflect.DeepEqual(rz.Zones, tc.want) {
+			t.Errorf("%s: got %v, expected %v", tc.desc, rz.Zones, tc.want)
+		}
+	}
+}
+
+func TestLoadMasterErrors(t *testing.T) {
+	dir := tempDir(t)
+	for _, tc := range []struct {
+		desc     string
+		contents string
+	}{
+		{"no SOA", "$ORIGIN example.com.\nwww 300 IN A 192.0.2.1\n"},
+		{"two SOAs", "$ORIGIN example.com.\n@ 300 IN SOA ns1 hostmaster 1 2 3 4 5\n@ 300 IN SOA ns2 hostmaster 1 2 3 4 5\n"},
+		{"bad record", "$ORIGIN example.com.\n@ 300 IN SOA ns1 hostmaster 1 2 3 4 5\nwww 300 IN A not-an-address\n"},
+	} {
+		path := filepath.Join(dir, "example.com.zone")
+		writeFile(t, path, tc.contents)
+		if _, err := workbench.LoadMaster(path); err == nil {
+			t.Errorf("%s: loaded without an error", tc.desc)
+		}
+	}
+	if _, err := workbench.LoadMaster(filepath.Join(dir, "missing.zone")); err == nil {
+		t.Errorf("Missing file loaded without an error")
+	}
+}
+
+func TestLoadFiles(t *testing.T) {
+	dir := tempDir(t)
+	zonesDir := filepath.Join(dir, "zones.d")
+	err := os.Mkdir(zonesDir, 0755)
+	if err != nil {
+		t.Fatalf("Failed to create zone directory: %s", err)
+	}
+	file := filepath.Join(dir, "main.yml")
+	writeFile(t, file, "zones:\n  example.com:\n    www.example.com:\n      a: [192.0.2.1]\n")
+	writeFile(t, filepath.Join(zonesDir, "example.org.zone"), "@ 300 IN SOA ns1 hostmaster 1 2 3 4 5\nwww 300 IN A 192.0.2.2\n")
+	writeFile(t, filepath.Join(zonesDir, "scripts.yaml"), "scripts:\n  s.example.com:\n    a:\n      responses:\n        - rcode: servfail\n")
+	// files without a zone file extension are ignored
+	writeFile(t, filepath.Join(zonesDir, "README"), "not a zone file")
+
+	rz, err := workbench.LoadFiles([]string{file}, []string{zonesDir})
+	if err != nil {
+		t.Fatalf("Failed to load files: %s", err)
+	}
+	want := map[string]map[string]map[string][]string{
+		"example.com":  {"www.example.com": {"a": {"192.0.2.1"}}},
+		"example.org.": {"www.example.org.": {"a": {"192.0.2.2"}}},
+	}
+	if !reflect.DeepEqual(rz.Zones, want) {
+		t.Errorf("Got zones %v, expected %v", rz.Zones, want)
+	}
+	if _, present := rz.Scripts["s.example.com"]["a"]; !present {
+		t.Errorf("Script from the zone directory wasn't loaded: %v", rz.Scripts)
+	}
+}
+
+func TestLoadFilesConflicts(t *testing.T) {
+	for _, tc := range []struct {
+		desc  string
+		a, b  string
+		thing string
+	}{
+		{
+			desc:  "zone",
+			a:     "zones:\n  example.com:\n    www.example.com:\n      a: [192.0.2.1]\n",
+			b:     "zones:\n  Example.COM.:\n    mail.example.com:\n      a: [192.0.2.2]\n",
+			thing: "zone example.com.",
+		},
+		{
+			desc:  "script",
+			a:     "scripts:\n  s.example.com:\n    a:\n      responses:\n        - rcode: servfail\n",
+			b:     "scripts:\n  s.example.com.:\n    A:\n      responses:\n        - rcode: refused\n",
+			thing: "script for s.example.com. A",
+		},
+		{
+			desc:  "ordering",
+			a:     "ordering:\n  example.com:\n    policy: cyclic\n",
+			b:     "ordering:\n  example.com.:\n    policy: shuffle\n",
+			thing: "ordering for zone example.com.",
+		},
+		{
+			desc:  "subnets",
+			a:     "subnets:\n  www.example.com:\n    a:\n      - subnet: 10.0.0.0/8\n        records: [192.0.2.1]\n",
+			b:     "subnets:\n  www.example.com:\n    a:\n      - subnet: 2001:db8::/32\n        records: [192.0.2.2]\n",
+			thing: "subnets for www.example.com. A",
+		},
+		{
+			desc:  "policy zone",
+			a:     "policies: [rpz.example]\n",
+			b:     "policies: [rpz.example.]\n",
+			thing: "policy zone rpz.example.",
+		},
+		{
+			desc:  "view",
+			a:     "views:\n  - name: internal\n",
+			b:     "views:\n  - name: internal\n",
+			thing: "view internal",
+		},
+	} {
+		dir := tempDir(t)
+		a, b := filepath.Join(dir, "a.yml"), filepath.Join(dir, "b.yml")
+		writeFile(t, a, tc.a)
+		writeFile(t, b, tc.b)
+		_, err := workbench.LoadFiles([]string{a, b}, nil)
+		if err == nil {
+			t.Errorf("%s: loaded without an error", tc.desc)
+			continue
+		}
+		want := tc.thing + " is defined in both " + a + " and " + b
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got error %q, expected %q", tc.desc, err, want)
+		}
+	}
+
+	// different record types for the same name can come from different files
+	dir := tempDir(t)
+	a, b := filepath.Join(dir, "a.yml"), filepath.Join(dir, "b.yml")
+	writeFile(t, a, "scripts:\n  s.example.com:\n    a:\n      responses:\n        - rcode: servfail\n")
+	writeFile(t, b, "scripts:\n  s.example.com:\n    aaaa:\n      responses:\n        - rcode: refused\n")
+	if _, err := workbench.LoadFiles([]string{a, b}, nil); err != nil {
+		t.Errorf("Scripts for different types conflicted: %s", err)
+	}
+}
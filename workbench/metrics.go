@@ -0,0 +1,162 @@
+package workbench
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/github.com/miekg/dns"
+)
+
+var latencyBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+var reloadBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10}
+
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(buf *bytes.Buffer, name string) {
+	for i, b := range h.buckets {
+		fmt.Fprintf(buf, "%s_bucket{le=\"%g\"} %d\n", name, b, h.counts[i])
+	}
+	fmt.Fprintf(buf, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(buf, "%s_sum %g\n", name, h.sum)
+	fmt.Fprintf(buf, "%s_count %d\n", name, h.count)
+}
+
+type queryLabels struct {
+	qType    string
+	rcode    string
+	protocol string
+	zone     string
+}
+
+type metrics struct {
+	mu             sync.Mutex
+	queries        map[queryLabels]uint64
+	queryLatency   *histogram
+	reloads        uint64
+	reloadFailures uint64
+	reloadDuration *histogram
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		queries:        make(map[queryLabels]uint64),
+		queryLatency:   newHistogram(latencyBuckets),
+		reloadDuration: newHistogram(reloadBuckets),
+	}
+}
+
+func (m *metrics) observeQuery(qe *QueryEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queries[queryLabels{qe.Type, qe.Rcode, qe.Protocol, qe.Zone}]++
+	m.queryLatency.observe(qe.Latency.Seconds())
+}
+
+func (m *metrics) observeReload(took time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		m.reloadFailures++
+		return
+	}
+	m.reloads++
+	m.reloadDuration.observe(took.Seconds())
+}
+
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func (m *metrics) write(buf *bytes.Buffer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(buf, "# HELP dns_workbench_queries_total Number of DNS queries answered.")
+	fmt.Fprintln(buf, "# TYPE dns_workbench_queries_total counter")
+	lines := []string{}
+	for l, c := range m.queries {
+		lines = append(lines, fmt.Sprintf(
+			"dns_workbench_queries_total{qtype=\"%s\",rcode=\"%s\",protocol=\"%s\",zone=\"%s\"} %d",
+			escapeLabel(l.qType),
+			escapeLabel(l.rcode),
+			escapeLabel(l.protocol),
+			escapeLabel(l.zone),
+			c,
+		))
+	}
+	sort.Strings(lines)
+	for _, l := range lines {
+		fmt.Fprintln(buf, l)
+	}
+
+	fmt.Fprintln(buf, "# HELP dns_workbench_query_duration_seconds Time taken to answer DNS queries.")
+	fmt.Fprintln(buf, "# TYPE dns_workbench_query_duration_seconds histogram")
+	m.queryLatency.write(buf, "dns_workbench_query_duration_seconds")
+
+	fmt.Fprintln(buf, "# HELP dns_workbench_reloads_total Number of successful zone reloads.")
+	fmt.Fprintln(buf, "# TYPE dns_workbench_reloads_total counter")
+	fmt.Fprintf(buf, "dns_workbench_reloads_total %d\n", m.reloads)
+	fmt.Fprintln(buf, "# HELP dns_workbench_reload_failures_total Number of zone reloads rejected because the zones couldn't be parsed.")
+	fmt.Fprintln(buf, "# TYPE dns_workbench_reload_failures_total counter")
+	fmt.Fprintf(buf, "dns_workbench_reload_failures_total %d\n", m.reloadFailures)
+	fmt.Fprintln(buf, "# HELP dns_workbench_reload_duration_seconds Time taken to parse and swap in new zones.")
+	fmt.Fprintln(buf, "# TYPE dns_workbench_reload_duration_seconds histogram")
+	m.reloadDuration.write(buf, "dns_workbench_reload_duration_seconds")
+}
+
+func (wb *Workbench) apiMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		sendError("Method not supported", w)
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	wb.m.write(buf)
+
+	wb.mu.RLock()
+	zoneCount, recordCount := 0, 0
+	for _, v := range wb.views {
+		for _, types := range v.z {
+			if _, present := types[dns.TypeSOA]; present {
+				zoneCount++
+			}
+			for _, records := range types {
+				recordCount += len(records)
+			}
+		}
+	}
+	wb.mu.RUnlock()
+	fmt.Fprintln(buf, "# HELP dns_workbench_zones Number of zones currently served across all views.")
+	fmt.Fprintln(buf, "# TYPE dns_workbench_zones gauge")
+	fmt.Fprintf(buf, "dns_workbench_zones %d\n", zoneCount)
+	fmt.Fprintln(buf, "# HELP dns_workbench_records Number of records currently served across all views.")
+	fmt.Fprintln(buf, "# TYPE dns_workbench_records gauge")
+	fmt.Fprintf(buf, "dns_workbench_records %d\n", recordCount)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,125 @@
+package workbench
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsFormat(t *testing.T) {
+	m := newMetrics()
+	m.observeQuery(&QueryEntry{Type: "A", Rcode: "NOERROR", Protocol: "udp", Zone: "example.com.", Latency: 2 * time.Millisecond})
+	m.observeQuery(&QueryEntry{Type: "A", Rcode: "NOERROR", Protocol: "udp", Zone: "example.com.", Latency: 300 * time.Millisecond})
+	m.observeQuery(&QueryEntry{Type: "TXT", Rcode: "NXDOMAIN", Protocol: "tcp", Zone: `we"ird\`, Latency: 2 * time.Second})
+	m.observeReload(20*time.Millisecond, nil)
+	m.observeReload(0, errors.New("bad zones"))
+
+	buf := new(bytes.Buffer)
+	m.write(buf)
+	want := `# HELP dns_workbench_queries_total Number of DNS queries answered.
+# TYPE dns_workbench_queries_total counter
+dns_workbench_queries_total{qtype="A",rcode="NOERROR",protocol="udp",zone="example.com."} 2
+dns_workbench_queries_total{qtype="TXT",rcode="NXDOMAIN",protocol="tcp",zone="we\"ird\\"} 1
+# HELP dns_workbench_query_duration_seconds Time taken to answer DNS queries.
+# TYPE dns_workbench_query_duration_seconds histogram
+dns_workbench_query_duration_seconds_bucket{le="0.0001"} 0
+dns_workbench_query_duration_seconds_bucket{le="0.00025"} 0
+dns_workbench_query_duration_seconds_bucket{le="0.0005"} 0
+dns_workbench_query_duration_seconds_bucket{le="0.001"} 0
+dns_workbench_query_duration_seconds_bucket{le="0.0025"} 1
+dns_workbench_query_duration_seconds_bucket{le="0.005"} 1
+dns_workbench_query_duration_seconds_bucket{le="0.01"} 1
+dns_workbench_query_duration_seconds_bucket{le="0.025"} 1
+dns_workbench_query_duration_seconds_bucket{le="0.05"} 1
+dns_workbench_query_duration_seconds_bucket{le="0.1"} 1
+dns_workbench_query_duration_seconds_bucket{le="0.25"} 1
+dns_workbench_query_duration_seconds_bucket{le="0.5"} 2
+dns_workbench_query_duration_seconds_bucket{le="1"} 2
+dns_workbench_query_duration_seconds_bucket{le="+Inf"} 3
+dns_workbench_query_duration_seconds_sum 2.302
+dns_workbench_query_duration_seconds_count 3
+# HELP dns_workbench_reloads_total Number of successful zone reloads.
+# TYPE dns_workbench_reloads_total counter
+dns_workbench_reloads_total 1
+# HELP dns_workbench_reload_failures_total Number of zone reloads rejected because the zones couldn't be parsed.
+# TYPE dns_workbench_reload_failures_total counter
+dns_workbench_reload_failures_total 1
+# HELP dns_workbench_reload_duration_seconds Time taken to parse and swap in new zones.
+# TYPE dns_workbench_reload_duration_seconds histogram
+dns_workbench_reload_duration_seconds_bucket{le="0.001"} 0
+dns_workbench_reload_duration_seconds_bucket{le="0.005"} 0
+dns_workbench_reload_duration_seconds_bucket{le="0.01"} 0
+dns_workbench_reload_duration_seconds_bucket{le="0.05"} 1
+dns_workbench_reload_duration_seconds_bucket{le="0.1"} 1
+dns_workbench_reload_duration_seconds_bucket{le="0.5"} 1
+dns_workbench_reload_duration_seconds_bucket{le="1"} 1
+dns_workbench_reload_duration_seconds_bucket{le="5"} 1
+dns_workbench_reload_duration_seconds_bucket{le="10"} 1
+dns_workbench_reload_duration_seconds_bucket{le="+Inf"} 1
+dns_workbench_reload_duration_seconds_sum 0.02
+dns_workbench_reload_duration_seconds_count 1
+`
+	if got := buf.String(); got != want {
+		t.Errorf("Got metrics:\n%s\nexpected:\n%s", got, want)
+	}
+}
+
+func TestMetricsAPI(t *testing.T) {
+	rz := RawZones{Zones: zonesOf(map[string]rawHosts{
+		"example.com": {"www.example.com": {"a": {"192.0.2.1", "192.0.2.2"}}},
+		"example.org": {"www.example.org": {"a": {"192.0.2.3"}}},
+	})}
+	wb, err := New(rz, Config{})
+	if err != nil {
+		t.Fatalf("Failed to create workbench: %s", err)
+	}
+	api := httptest.NewServer(wb.APIHandler())
+	defer api.Close()
+
+	resp, err := http.Get(api.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("Request for metrics failed: %s", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read metrics: %s", err)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/plain; version=0.0.4" {
+		t.Errorf("Got content type %q, expected the Prometheus text format", ct)
+	}
+
+	// every sample needs a TYPE line for its metric earlier in the output
+	typed := make(map[string]bool)
+	sample := regexp.MustCompile(`^([a-z_]+?)(_bucket|_sum|_count)?(\{[^}]*\})? [0-9.e+-]+$`)
+	for _, line := range strings.Split(strings.TrimSuffix(string(body), "\n"), "\n") {
+		if strings.HasPrefix(line, "# TYPE ") {
+			typed[strings.Fields(line)[2]] = true
+			continue
+		}
+		if strings.HasPrefix(line, "# HELP ") {
+			continue
+		}
+		m := sample.FindStringSubmatch(line)
+		if m == nil {
+			t.Errorf("Malformed line %q", line)
+			continue
+		}
+		if !typed[m[1]] && !typed[m[1]+m[2]] {
+			t.Errorf("Sample %q has no TYPE line", line)
+		}
+	}
+
+	// two zones each with a generated SOA
+	for _, want := range []string{"dns_workbench_zones 2\n", "dns_workbench_records 5\n"} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("Metrics don't contain %q:\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package workbench
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+
+	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/github.com/miekg/dns"
+)
+
+// Ordering policies control how an RRset is returned in answers
+const (
+	// return the records in file order
+	policyFixed = "fixed"
+	// rotate the records by one on every query
+	policyCyclic = "cyclic"
+	// return the records in a random order
+	policyShuffle = "shuffle"
+	// return a weighted random subset of the records
+	policyWeighted = "weighted"
+)
+
+// RawOrder is the ordering policy for a single RRset
+type RawOrder struct {
+	Policy  string `yaml:"policy,omitempty" json:"policy"`
+	Weights []int  `yaml:"weights,omitempty" json:"weights"`
+	Count   int    `yaml:"count,omitempty" json:"count"`
+}
+
+// RawZoneOrder is the ordering policy for every RRset in a zone, RRsets
+// holds per RRset overrides keyed by host and type
+type RawZoneOrder struct {
+	Policy string                         `yaml:"policy,omitempty" json:"policy"`
+	Count  int                            `yaml:"count,omitempty" json:"count"`
+	RRsets map[string]map[string]RawOrder `yaml:"rrsets,omitempty" json:"rrsets"`
+}
+
+type order struct {
+	mu      sync.Mutex
+	policy  string
+	weights []int
+	count   int
+	pos     int
+}
+
+type orderings map[string]map[uint16]*order
+
+func newOrder(ro RawOrder, records []dns.RR) (*order, error) {
+	o := &order{policy: strings.ToLower(ro.Policy), count: ro.Count}
+	switch o.policy {
+	case "":
+		o.policy = policyFixed
+	case policyFixed, policyCyclic, policyShuffle:
+	case policyWeighted:
+		if len(ro.Weights) == 0 {
+			o.weights = make([]int, len(records))
+			for i := range o.weights {
+				o.weights[i] = 1
+			}
+		} else if len(ro.Weights) != len(records) {
+			return nil, fmt.Errorf("Expected %d weights, got %d", len(records), len(ro.Weights))
+		} else {
+			total := 0
+			for _, w := range ro.Weights {
+				if w < 0 {
+					return nil, fmt.Errorf("Weights cannot be negative")
+				}
+				total += w
+			}
+			if total == 0 {
+				return nil, fmt.Errorf("At least one weight must be positive")
+			}
+			o.weights = ro.Weights
+		}
+		if o.count <= 0 {
+			o.count = 1
+		}
+	default:
+		return nil, fmt.Errorf("Invalid ordering policy: %s", ro.Policy)
+	}
+	return o, nil
+}
+
+func constructOrderings(rz RawZones, z zones) (orderings, error) {
+	o := make(orderings)
+	set := func(host string, rType uint16, ro RawOrder) error {
+		no, err := newOrder(ro, z[host][rType])
+		if err != nil {
+			return fmt.Errorf("Bad ordering for %s: %v", host, err)
+		}
+		if _, present := o[host]; !present {
+			o[host] = make(map[uint16]*order)
+		}
+		o[host][rType] = no
+		return nil
+	}
+
+	rawHosts := make(map[string]map[string]map[string][]string)
+	for zoneName, hosts := range rz.Zones {
+		rawHosts[dns.Fqdn(zoneName)] = hosts
+	}
+
+	for zoneName, zo := range rz.Ordering {
+		hosts, present := rawHosts[dns.Fqdn(zoneName)]
+		if !present {
+			return nil, fmt.Errorf("Ordering defined for unknown zone %s", zoneName)
+		}
+
+		if zo.Policy != "" {
+			for host := range hosts {
+				host = dns.Fqdn(host)
+				for rType := range z[host] {
+					err := set(host, rType, RawOrder{Policy: zo.Policy, Count: zo.Count})
+					if err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+
+		for host, types := range zo.RRsets {
+			host = dns.Fqdn(host)
+			for typeStr, ro := range types {
+				rType, present := dns.StringToType[strings.ToUpper(typeStr)]
+				if !present {
+					return nil, fmt.Errorf("Invalid record type")
+				}
+				if len(z[host][rType]) == 0 {
+					return nil, fmt.Errorf("Ordering defined for missing RRset %s %s", host, typeStr)
+				}
+				err := set(host, rType, ro)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return o, nil
+}
+
+// apply returns the records for a RRset in the order specified by its policy
+func (o orderings) apply(name string, qType uint16, records []dns.RR) []dns.RR {
+	ord, present := o[name][qType]
+	if !present || len(records) < 2 {
+		return records
+	}
+
+	ordered := make([]dns.RR, 0, len(records))
+	switch ord.policy {
+	case policyFixed:
+		return records
+	case policyCyclic:
+		ord.mu.Lock()
+		start := ord.pos
+		ord.pos = (ord.pos + 1) % len(records)
+		ord.mu.Unlock()
+		ordered = append(ordered, records[start:]...)
+		ordered = append(ordered, records[:start]...)
+	case policyShuffle:
+		for _, i := range rand.Perm(len(records)) {
+			ordered = append(ordered, records[i])
+		}
+	case policyWeighted:
+		weights := make([]int, len(ord.weights))
+		copy(weights, ord.weights)
+		for len(ordered) < ord.count {
+			total := 0
+			for _, w := range weights {
+				total += w
+			}
+			if total == 0 {
+				break
+			}
+			n := rand.Intn(total)
+			for i, w := range weights {
+				if n < w {
+					ordered = append(ordered, records[i])
+					weights[i] = 0
+					break
+				}
+				n -= w
+			}
+		}
+	}
+	return ordered
+}
@@ -0,0 +1,179 @@
+package workbench_test
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/github.com/miekg/dns"
+	"github.com/rolandshoemaker/dns-workbench/workbench/workbenchtest"
+)
+
+const orderingZones = `
+zones:
+  example.com:
+    fixed.example.com:
+      a: [192.0.2.1, 192.0.2.2, 192.0.2.3]
+    cyclic.example.com:
+      a: [192.0.2.1, 192.0.2.2, 192.0.2.3]
+    shuffle.example.com:
+      a: [192.0.2.1, 192.0.2.2, 192.0.2.3]
+    weighted.example.com:
+      a: [192.0.2.1, 192.0.2.2, 192.0.2.3]
+    pair.example.com:
+      a: [192.0.2.1, 192.0.2.2, 192.0.2.3]
+    all.example.com:
+      a: [192.0.2.1, 192.0.2.2, 192.0.2.3]
+  example.org:
+    www.example.org:
+      a: [192.0.2.1, 192.0.2.2]
+    override.example.org:
+      a: [192.0.2.1, 192.0.2.2]
+ordering:
+  example.com:
+    rrsets:
+      cyclic.example.com:
+        a:
+          policy: cyclic
+      shuffle.example.com:
+        a:
+          policy: shuffle
+      weighted.example.com:
+        a:
+          policy: weighted
+          weights: [1, 0, 0]
+      pair.example.com:
+        a:
+          policy: weighted
+          weights: [1, 1, 0]
+          count: 5
+      all.example.com:
+        a:
+          policy: weighted
+          count: 3
+  example.org:
+    policy: cyclic
+    rrsets:
+      override.example.org:
+        a:
+          policy: fixed
+`
+
+func TestOrdering(t *testing.T) {
+	s := workbenchtest.NewServer(t, orderingZones)
+	a := func(name string) []string {
+		return values(ask(t, s.Addr(), name, dns.TypeA).Answer)
+	}
+	sorted := func(v []string) []string {
+		out := append([]string{}, v...)
+		sort.Strings(out)
+		return out
+	}
+	all := []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"}
+
+	for i := 0; i < 3; i++ {
+		if got := a("fixed.example.com"); !reflect.DeepEqual(got, all) {
+			t.Errorf("fixed: got %q, expected %q", got, all)
+		}
+		if got := a("override.example.org"); !reflect.DeepEqual(got, []string{"192.0.2.1", "192.0.2.2"}) {
+			t.Errorf("RRset override of zone policy: got %q", got)
+		}
+	}
+
+	for i, want := range [][]string{
+		{"192.0.2.1", "192.0.2.2", "192.0.2.3"},
+		{"192.0.2.2", "192.0.2.3", "192.0.2.1"},
+		{"192.0.2.3", "192.0.2.1", "192.0.2.2"},
+		{"192.0.2.1", "192.0.2.2", "192.0.2.3"},
+	} {
+		if got := a("cyclic.example.com"); !reflect.DeepEqual(got, want) {
+			t.Errorf("cyclic: query %d got %q, expected %q", i+1, got, want)
+		}
+	}
+	for i, want := range [][]string{
+		{"192.0.2.1", "192.0.2.2"},
+		{"192.0.2.2", "192.0.2.1"},
+		{"192.0.2.1", "192.0.2.2"},
+	} {
+		if got := a("www.example.org"); !reflect.DeepEqual(got, want) {
+			t.Errorf("zone policy: query %d got %q, expected %q", i+1, got, want)
+		}
+	}
+
+	orders := make(map[string]bool)
+	for i := 0; i < 50; i++ {
+		got := a("shuffle.example.com")
+		if !reflect.DeepEqual(sorted(got), all) {
+			t.Fatalf("shuffle: got %q, expected every record", got)
+		}
+		orders[strings.Join(got, " ")] = true
+	}
+	if len(orders) < 2 {
+		t.Errorf("shuffle: got the same order for 50 queries")
+	}
+
+	orders = make(map[string]bool)
+	for i := 0; i < 50; i++ {
+		if got := a("weighted.example.com"); !reflect.DeepEqual(got, []string{"192.0.2.1"}) {
+			t.Fatalf("weighted: got %q, expected only the record with a weight", got)
+		}
+		// records with no weight are never returned, even when count is higher
+		if got := a("pair.example.com"); !reflect.DeepEqual(sorted(got), []string{"192.0.2.1", "192.0.2.2"}) {
+			t.Fatalf("weighted count: got %q, expected the two records with a weight", got)
+		}
+		got := a("all.example.com")
+		if !reflect.DeepEqual(sorted(got), all) {
+			t.Fatalf("weighted default weights: got %q, expected every record", got)
+		}
+		orders[strings.Join(got, " ")] = true
+	}
+	if len(orders) < 2 {
+		t.Errorf("weighted: got the same order for 50 queries")
+	}
+}
+
+func TestOrderingErrors(t *testing.T) {
+	for _, tc := range []struct {
+		desc  string
+		order string
+	}{
+		{"unknown policy", "policy: sorted"},
+		{"negative weight", "policy: weighted\n          weights: [1, -1]"},
+		{"zero weights", "policy: weighted\n          weights: [0, 0]"},
+		{"wrong number of weights", "policy: weighted\n          weights: [1]"},
+	} {
+		zones := `
+zones:
+  example.com:
+    www.example.com:
+      a: [192.0.2.1, 192.0.2.2]
+ordering:
+  example.com:
+    rrsets:
+      www.example.com:
+        a:
+          ` + tc.order + "\n"
+		if err := zonesError(zones); err == nil {
+			t.Errorf("%s: workbench was created, expected an error", tc.desc)
+		}
+	}
+
+	for _, tc := range []struct {
+		desc  string
+		zones string
+	}{
+		{
+			desc:  "unknown zone",
+			zones: "ordering:\n  example.com:\n    policy: cyclic\n",
+		},
+		{
+			desc:  "missing RRset",
+			zones: "zones:\n  example.com:\n    example.com:\n      a: [192.0.2.1]\nordering:\n  example.com:\n    rrsets:\n      www.example.com:\n        a:\n          policy: cyclic\n",
+		},
+	} {
+		if err := zonesError(tc.zones); err == nil {
+			t.Errorf("%s: workbench was created, expected an error", tc.desc)
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package workbench
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/github.com/miekg/dns"
+)
+
+// Actions a response policy rule can take, set using the CNAME target of the
+// rule in the policy zone. Any other records are local data.
+const (
+	policyNXDomain = iota
+	policyNoData
+	policyPassthru
+	policyDrop
+	policyTCPOnly
+	policyLocalData
+)
+
+var policyActionNames = map[int]string{
+	policyNXDomain:  "NXDOMAIN",
+	policyNoData:    "NODATA",
+	policyPassthru:  "PASSTHRU",
+	policyDrop:      "DROP",
+	policyTCPOnly:   "TCP-ONLY",
+	policyLocalData: "LOCAL-DATA",
+}
+
+// policyRule is a single trigger in a response policy zone
+type policyRule struct {
+	zone    string
+	trigger string
+	owner   string
+	action  int
+	records []dns.RR
+}
+
+func (pr *policyRule) String() string {
+	return fmt.Sprintf("%s %s %s %s", pr.zone, pr.trigger, pr.owner, policyActionNames[pr.action])
+}
+
+type ipRule struct {
+	n    *net.IPNet
+	rule *policyRule
+}
+
+// policyZone holds the rules of a response policy zone (RPZ), QNAME and
+// NSDNAME rules are keyed by lower case name with wildcards starting with *.
+type policyZone struct {
+	qnames  map[string]*policyRule
+	ips     []ipRule
+	nsnames map[string]*policyRule
+}
+
+// policies are the policy zones of a view in the order they are checked
+type policies []*policyZone
+
+// parseRPZAddress parses the owner name of an rpz-ip rule, e.g.
+// 24.0.2.0.192 for 192.0.2.0/24 or 48.zz.db8.2001 for 2001:db8::/48
+func parseRPZAddress(labels []string) (*net.IPNet, error) {
+	if len(labels) < 2 {
+		return nil, fmt.Errorf("Invalid address trigger")
+	}
+	prefix, err := strconv.Atoi(labels[0])
+	if err != nil {
+		return nil, fmt.Errorf("Invalid address trigger prefix length %s", labels[0])
+	}
+	parts := []string{}
+	for i := len(labels) - 1; i > 0; i-- {
+		parts = append(parts, labels[i])
+	}
+	if len(parts) == 4 && !strings.Contains(strings.Join(parts, ""), "zz") {
+		if ip := net.ParseIP(strings.Join(parts, ".")).To4(); ip != nil && prefix <= 32 {
+			return &net.IPNet{IP: ip.Mask(net.CIDRMask(prefix, 32)), Mask: net.CIDRMask(prefix, 32)}, nil
+		}
+	}
+	addr := strings.Replace(strings.Join(parts, ":"), "zz", "", 1)
+	if strings.HasPrefix(addr, ":") {
+		addr = ":" + addr
+	}
+	if strings.HasSuffix(addr, ":") {
+		addr += ":"
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil || ip.To4() != nil || prefix > 128 {
+		return nil, fmt.Errorf("Invalid address trigger %s", strings.Join(labels, "."))
+	}
+	return &net.IPNet{IP: ip.Mask(net.CIDRMask(prefix, 128)), Mask: net.CIDRMask(prefix, 128)}, nil
+}
+
+// newPolicyRule returns the rule for the records at owner, which must
+// either be a single CNAME naming an action or local data
+func newPolicyRule(zone, trigger, owner string, records []dns.RR) *policyRule {
+	pr := &policyRule{zone: zone, trigger: trigger, owner: owner, action: policyLocalData, records: records}
+	if len(records) != 1 || records[0].Header().Rrtype != dns.TypeCNAME {
+		return pr
+	}
+	switch strings.ToLower(records[0].(*dns.CNAME).Target) {
+	case ".":
+		pr.action = policyNXDomain
+	case "*.":
+		pr.action = policyNoData
+	case "rpz-passthru.":
+		pr.action = policyPassthru
+	case "rpz-drop.":
+		pr.action = policyDrop
+	case "rpz-tcp-only.":
+		pr.action = policyTCPOnly
+	}
+	if pr.action != policyLocalData {
+		pr.records = nil
+	}
+	return pr
+}
+
+// constructPolicies builds the policy zones named in rz.Policies, which must
+// also be defined in rz.Zones
+func constructPolicies(rz RawZones) (policies, error) {
+	p := policies{}
+	for _, name := range rz.Policies {
+		zone := strings.ToLower(dns.Fqdn(name))
+		hosts, present := rz.Zones[zoneKey(rz.Zones, zone)]
+		if !present {
+			return nil, fmt.Errorf("Policy zone %s isn't defined", zone)
+		}
+		pz := &policyZone{
+			qnames:  make(map[string]*policyRule),
+			nsnames: make(map[string]*policyRule),
+		}
+		for host, types := range hosts {
+			host = strings.ToLower(dns.Fqdn(host))
+			if !dns.IsSubDomain(zone, host) || host == zone {
+				continue
+			}
+			records := []dns.RR{}
+			for t, values := range types {
+				if strings.ToLower(t) == "soa" || strings.ToLower(t) == "ns" {
+					continue
+				}
+				for _, presentation := range values {
+					rr, err := newRecord(host, t, presentation)
+					if err != nil {
+						return nil, err
+					}
+					records = append(records, rr)
+				}
+			}
+			if len(records) == 0 {
+				continue
+			}
+
+			owner := strings.TrimSuffix(host, "."+zone)
+			labels := dns.SplitDomainName(owner)
+			switch labels[len(labels)-1] {
+			case "rpz-ip":
+				n, err := parseRPZAddress(labels[:len(labels)-1])
+				if err != nil {
+					return nil, fmt.Errorf("Policy zone %s: %v", zone, err)
+				}
+				pz.ips = append(pz.ips, ipRule{n: n, rule: newPolicyRule(zone, "ip", n.String(), records)})
+			case "rpz-nsdname":
+				name := dns.Fqdn(strings.TrimSuffix(owner, ".rpz-nsdname"))
+				pz.nsnames[name] = newPolicyRule(zone, "nsdname", name, records)
+			case "rpz-nsip", "rpz-client-ip":
+				return nil, fmt.Errorf("Policy zone %s: %s triggers aren't supported", zone, labels[len(labels)-1])
+			default:
+				name := dns.Fqdn(owner)
+				pz.qnames[name] = newPolicyRule(zone, "qname", name, records)
+			}
+		}
+		p = append(p, pz)
+	}
+	return p, nil
+}
+
+// matchName returns the rule for name in names, an exact match is preferred
+// over the closest wildcard
+func matchName(names map[string]*policyRule, name string) *policyRule {
+	name = strings.ToLower(name)
+	if rule, present := names[name]; present {
+		return rule
+	}
+	for off, end := dns.NextLabel(name, 0); !end; off, end = dns.NextLabel(name, off) {
+		if rule, present := names["*."+name[off:]]; present {
+			return rule
+		}
+	}
+	return names["*."]
+}
+
+// matchQuery returns the QNAME rule for name from the first policy zone
+// that has one
+func (p policies) matchQuery(name string) *policyRule {
+	for _, pz := range p {
+		if rule := matchName(pz.qnames, name); rule != nil {
+			return rule
+		}
+	}
+	return nil
+}
+
+// matchResponse returns the first rule triggered by an address in the answer
+// or a nameserver name in the answer or authority sections of m, within a
+// policy zone the most specific address rule is used
+func (p policies) matchResponse(m *dns.Msg) *policyRule {
+	for _, pz := range p {
+		var best *ipRule
+		for _, rr := range m.Answer {
+			var ip net.IP
+			switch r := rr.(type) {
+			case *dns.A:
+				ip = r.A
+			case *dns.AAAA:
+				ip = r.AAAA
+			default:
+				continue
+			}
+			for i, ir := range pz.ips {
+				if !ir.n.Contains(ip) {
+					continue
+				}
+				if best == nil || prefixLen(ir.n) > prefixLen(best.n) {
+					best = &pz.ips[i]
+				}
+			}
+		}
+		if best != nil {
+			return best.rule
+		}
+		for _, rr := range append(append([]dns.RR{}, m.Answer...), m.Ns...) {
+			if ns, ok := rr.(*dns.NS); ok {
+				if rule := matchName(pz.nsnames, ns.Ns); rule != nil {
+					return rule
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func prefixLen(n *net.IPNet) int {
+	ones, _ := n.Mask.Size()
+	return ones
+}
+
+// overTCP returns true if the query was received over a connection, which
+// includes DNS over TLS and HTTPS
+func overTCP(w dns.ResponseWriter) bool {
+	_, ok := w.RemoteAddr().(*net.TCPAddr)
+	return ok
+}
+
+// apply rewrites m, the response to r, according to the rule, returning nil
+// if no response should be sent
+func (pr *policyRule) apply(w dns.ResponseWriter, r, m *dns.Msg) *dns.Msg {
+	q := r.Question[0]
+	extra := []dns.RR{}
+	for _, rr := range m.Extra {
+		if t := rr.Header().Rrtype; t == dns.TypeOPT || t == dns.TypeTSIG {
+			extra = append(extra, rr)
+		}
+	}
+	switch pr.action {
+	case policyPassthru:
+		return m
+	case policyDrop:
+		return nil
+	case policyTCPOnly:
+		if overTCP(w) {
+			return m
+		}
+		m.Truncated = true
+		m.Answer, m.Ns, m.Extra = nil, nil, extra
+		return m
+	}
+
+	m.Answer, m.Ns, m.Extra = nil, nil, extra
+	m.Rcode = dns.RcodeSuccess
+	switch pr.action {
+	case policyNXDomain:
+		m.Rcode = dns.RcodeNameError
+	case policyLocalData:
+		var cname dns.RR
+		for _, rr := range pr.records {
+			t := rr.Header().Rrtype
+			if t == dns.TypeCNAME {
+				cname = rr
+			}
+			if t == q.Qtype || q.Qtype == dns.TypeANY {
+				local := dns.Copy(rr)
+				local.Header().Name = q.Name
+				m.Answer = append(m.Answer, local)
+			}
+		}
+		if len(m.Answer) == 0 && cname != nil {
+			local := dns.Copy(cname)
+			local.Header().Name = q.Name
+			m.Answer = append(m.Answer, local)
+		}
+	}
+	return m
+}
+
+// policyWriter applies response policies to the response written to the
+// client, rule is set when the query name triggered a rule
+type policyWriter struct {
+	dns.ResponseWriter
+	p    policies
+	r    *dns.Msg
+	rule *policyRule
+}
+
+func (pw *policyWriter) WriteMsg(m *dns.Msg) error {
+	rule := pw.rule
+	if rule == nil {
+		rule = pw.p.matchResponse(m)
+	}
+	if rule != nil {
+		if rw, ok := pw.ResponseWriter.(*recordingWriter); ok {
+			rw.policy = rule.String()
+		}
+		m = rule.apply(pw.ResponseWriter, pw.r, m)
+		if m == nil {
+			return nil
+		}
+	}
+	return pw.ResponseWriter.WriteMsg(m)
+}
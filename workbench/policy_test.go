@@ -0,0 +1,46 @@
+package workbench
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseRPZAddress(t *testing.T) {
+	for _, tc := range []struct {
+		owner string
+		want  string
+		err   bool
+	}{
+		{owner: "24.0.2.0.192", want: "192.0.2.0/24"},
+		{owner: "32.1.2.0.192", want: "192.0.2.1/32"},
+		// host bits are masked off
+		{owner: "24.1.2.0.192", want: "192.0.2.0/24"},
+		{owner: "0.0.0.0.0", want: "0.0.0.0/0"},
+		{owner: "128.1.zz.db8.2001", want: "2001:db8::1/128"},
+		{owner: "48.zz.db8.2001", want: "2001:db8::/48"},
+		{owner: "128.1.zz", want: "::1/128"},
+		{owner: "64.0.0.0.0.0.0.db8.2001", want: "2001:db8::/64"},
+		{owner: "64.0.0.0.0.0.db8.2001", err: true},
+		{owner: "24", err: true},
+		{owner: "x.0.2.0.192", err: true},
+		{owner: "33.1.2.0.192", err: true},
+		{owner: "24.0.2.0.300", err: true},
+		{owner: "129.1.zz.db8.2001", err: true},
+		{owner: "64.1.zz.db8.zz.2001", err: true},
+	} {
+		n, err := parseRPZAddress(strings.Split(tc.owner, "."))
+		if tc.err {
+			if err == nil {
+				t.Errorf("parseRPZAddress(%s) = %s, expected an error", tc.owner, n)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseRPZAddress(%s) failed: %s", tc.owner, err)
+			continue
+		}
+		if n.String() != tc.want {
+			t.Errorf("parseRPZAddress(%s) = %s, expected %s", tc.owner, n, tc.want)
+		}
+	}
+}
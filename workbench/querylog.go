@@ -0,0 +1,242 @@
+package workbench
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/github.com/miekg/dns"
+)
+
+// QueryEntry describes a query received by the DNS server and the response
+// that was sent
+type QueryEntry struct {
+	Time     time.Time     `json:"time"`
+	Client   string        `json:"client"`
+	Protocol string        `json:"protocol"`
+	ID       uint16        `json:"id"`
+	Flags    []string      `json:"flags"`
+	Name     string        `json:"name"`
+	Type     string        `json:"type"`
+	Class    string        `json:"class"`
+	Zone     string        `json:"zone,omitempty"`
+	EDNS     []string      `json:"edns,omitempty"`
+	Upstream string        `json:"upstream,omitempty"`
+	Policy   string        `json:"policy,omitempty"`
+	Rcode    string        `json:"rcode"`
+	Answers  int           `json:"answers"`
+	Size     int           `json:"size"`
+	Latency  time.Duration `json:"latency_ns"`
+}
+
+func (qe *QueryEntry) String() string {
+	s := fmt.Sprintf(
+		"Query from %s over %s id=%d flags=%s [%s %s] %s rcode=%s answers=%d size=%d latency=%s",
+		qe.Client,
+		qe.Protocol,
+		qe.ID,
+		strings.Join(qe.Flags, ","),
+		qe.Class,
+		qe.Type,
+		qe.Name,
+		qe.Rcode,
+		qe.Answers,
+		qe.Size,
+		qe.Latency,
+	)
+	if len(qe.EDNS) > 0 {
+		s += fmt.Sprintf(" edns=%s", strings.Join(qe.EDNS, ","))
+	}
+	if qe.Upstream != "" {
+		s += fmt.Sprintf(" upstream=%s", qe.Upstream)
+	}
+	if qe.Policy != "" {
+		s += fmt.Sprintf(" policy=%q", qe.Policy)
+	}
+	return s
+}
+
+func newQueryEntry(w dns.ResponseWriter, r, m *dns.Msg, zone string, started time.Time) *QueryEntry {
+	qe := &QueryEntry{
+		Time:    started,
+		Client:  w.RemoteAddr().String(),
+		ID:      r.Id,
+		Zone:    zone,
+		Latency: time.Since(started),
+	}
+	switch w.RemoteAddr().(type) {
+	case *net.TCPAddr:
+		qe.Protocol = "tcp"
+	default:
+		qe.Protocol = "udp"
+	}
+	switch w.(type) {
+	case *tlsWriter:
+		qe.Protocol = "tls"
+	case *httpWriter:
+		qe.Protocol = "doh"
+	}
+
+	qe.Flags = []string{}
+	if r.RecursionDesired {
+		qe.Flags = append(qe.Flags, "rd")
+	}
+	if r.CheckingDisabled {
+		qe.Flags = append(qe.Flags, "cd")
+	}
+	if r.AuthenticatedData {
+		qe.Flags = append(qe.Flags, "ad")
+	}
+	if o := r.IsEdns0(); o != nil {
+		if o.Do() {
+			qe.Flags = append(qe.Flags, "do")
+		}
+		qe.EDNS = append(qe.EDNS, fmt.Sprintf("udp=%d", o.UDPSize()))
+		for _, opt := range o.Option {
+			qe.EDNS = append(qe.EDNS, fmt.Sprintf("%d=%s", opt.Option(), opt.String()))
+		}
+	}
+
+	if len(r.Question) > 0 {
+		qe.Name = r.Question[0].Name
+		qe.Type = dns.TypeToString[r.Question[0].Qtype]
+		qe.Class = dns.ClassToString[r.Question[0].Qclass]
+	}
+	if m != nil {
+		qe.Rcode = dns.RcodeToString[m.Rcode]
+		qe.Answers = len(m.Answer)
+		qe.Size = m.Len()
+	}
+	return qe
+}
+
+// recordingWriter keeps a copy of the response written to the client so it
+// can be logged once the handler is done
+type recordingWriter struct {
+	dns.ResponseWriter
+	msg *dns.Msg
+	// the response policy rule applied to the response, if any
+	policy string
+}
+
+func (rw *recordingWriter) WriteMsg(m *dns.Msg) error {
+	rw.msg = m
+	return rw.ResponseWriter.WriteMsg(m)
+}
+
+type queryLog struct {
+	mu     sync.Mutex
+	json   bool
+	out    io.Writer
+	logger *log.Logger
+}
+
+func newQueryLog(out io.Writer, format string, prefix string) (*queryLog, error) {
+	ql := &queryLog{out: out}
+	switch format {
+	case "json":
+		ql.json = true
+	case "text":
+		ql.logger = log.New(out, prefix, log.Flags())
+	default:
+		return nil, fmt.Errorf("Invalid query log format: %s", format)
+	}
+	return ql, nil
+}
+
+func (ql *queryLog) log(qe *QueryEntry) {
+	if !ql.json {
+		ql.logger.Println(qe.String())
+		return
+	}
+	line, err := json.Marshal(qe)
+	if err != nil {
+		return
+	}
+	ql.mu.Lock()
+	defer ql.mu.Unlock()
+	ql.out.Write(append(line, '\n'))
+}
+
+// RotatingFile is a file that is moved to path.1 once it grows larger than
+// maxSize, keeping at most backups old files around
+type RotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	backups int
+	f       *os.File
+	size    int64
+}
+
+// OpenRotatingFile opens path for appending, if maxSize is 0 the file is never
+// rotated
+func OpenRotatingFile(path string, maxSize int64, backups int) (*RotatingFile, error) {
+	rf := &RotatingFile{path: path, maxSize: maxSize, backups: backups}
+	err := rf.open()
+	if err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f = f
+	rf.size = info.Size()
+	return nil
+}
+
+func (rf *RotatingFile) rotate() error {
+	err := rf.f.Close()
+	if err != nil {
+		return err
+	}
+	if rf.backups > 0 {
+		for i := rf.backups - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", rf.path, i), fmt.Sprintf("%s.%d", rf.path, i+1))
+		}
+		err = os.Rename(rf.path, rf.path+".1")
+	} else {
+		err = os.Remove(rf.path)
+	}
+	if err != nil {
+		return err
+	}
+	return rf.open()
+}
+
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		err := rf.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// Close closes the underlying file
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.f.Close()
+}
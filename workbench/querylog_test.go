@@ -0,0 +1,232 @@
+package workbench_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/github.com/miekg/dns"
+	"github.com/rolandshoemaker/dns-workbench/workbench"
+	"github.com/rolandshoemaker/dns-workbench/workbench/workbenchtest"
+)
+
+// readFiles returns the contents of each of paths, missing files are empty
+func readFiles(t *testing.T, paths ...string) []string {
+	t.Helper()
+	out := []string{}
+	for _, p := range paths {
+		contents, err := ioutil.ReadFile(p)
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatalf("Failed to read %s: %s", p, err)
+		}
+		out = append(out, string(contents))
+	}
+	return out
+}
+
+func TestRotatingFile(t *testing.T) {
+	for _, tc := range []struct {
+		desc    string
+		backups int
+		writes  []string
+		// contents of the file and its backups, oldest last
+		want []string
+	}{
+		{
+			desc:    "under the limit",
+			backups: 2,
+			writes:  []string{"aaaa\n", "bbbb\n"},
+			want:    []string{"aaaa\nbbbb\n", "", ""},
+		},
+		{
+			desc:    "rotated",
+			backups: 2,
+			writes:  []string{"aaaa\n", "bbbb\n", "cccc\n"},
+			want:    []string{"cccc\n", "aaaa\nbbbb\n", ""},
+		},
+		{
+			desc:    "oldest backup dropped",
+			backups: 2,
+			writes:  []string{"aaaa\n", "bbbb\n", "cccc\n", "dddd\n", "eeee\n", "ffff\n", "gggg\n"},
+			want:    []string{"gggg\n", "eeee\nffff\n", "cccc\ndddd\n"},
+		},
+		{
+			desc:    "no backups",
+			backups: 0,
+			writes:  []string{"aaaa\n", "bbbb\n", "cccc\n"},
+			want:    []string{"cccc\n", "", ""},
+		},
+		{
+			// a write bigger than the limit still goes to a file of its own
+			desc:    "large write",
+			backups: 1,
+			writes:  []string{"aaaa\n", "bbbbbbbbbbbbbbb\n", "cccc\n"},
+			want:    []string{"cccc\n", "bbbbbbbbbbbbbbb\n", ""},
+		},
+	} {
+		dir, err := ioutil.TempDir("", "rotating")
+		if err != nil {
+			t.Fatalf("Failed to create temporary directory: %s", err)
+		}
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "queries.log")
+
+		rf, err := workbench.OpenRotatingFile(path, 10, tc.backups)
+		if err != nil {
+			t.Fatalf("%s: failed to open file: %s", tc.desc, err)
+		}
+		for _, w := range tc.writes {
+			_, err = rf.Write([]byte(w))
+			if err != nil {
+				t.Fatalf("%s: failed to write: %s", tc.desc, err)
+			}
+		}
+		err = rf.Close()
+		if err != nil {
+			t.Fatalf("%s: failed to close file: %s", tc.desc, err)
+		}
+		got := readFiles(t, path, path+".1", path+".2")
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got files %q, expected %q", tc.desc, got, tc.want)
+		}
+	}
+}
+
+func TestRotatingFileReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotating")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "queries.log")
+
+	// the size of what is already in the file counts towards the limit
+	for _, w := range []string{"aaaa\n", "bbbb\n", "cccc\n"} {
+		rf, err := workbench.OpenRotatingFile(path, 10, 1)
+		if err != nil {
+			t.Fatalf("Failed to open file: %s", err)
+		}
+		rf.Write([]byte(w))
+		rf.Close()
+	}
+	want := []string{"cccc\n", "aaaa\nbbbb\n"}
+	if got := readFiles(t, path, path+".1"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Got files %q, expected %q", got, want)
+	}
+}
+
+// syncBuffer is a bytes.Buffer that is safe to write to from the server
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (sb *syncBuffer) Write(p []byte) (int, error) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	return sb.buf.Write(p)
+}
+
+func (sb *syncBuffer) String() string {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	return sb.buf.String()
+}
+
+// logQueries returns the query log written for a query for www.example.com A
+// over UDP and a query for missing.example.com AAAA over TCP with EDNS
+func logQueries(t *testing.T, format, prefix string) (string, []string) {
+	t.Helper()
+	out := &syncBuffer{}
+	logged := make(chan *workbench.QueryEntry, 2)
+	s := workbenchtest.NewServerWithConfig(t, exampleZones, workbench.Config{
+		Listeners:      []workbench.Listener{{Addr: "127.0.0.1:0"}},
+		QueryLog:       out,
+		QueryLogFormat: format,
+		QueryLogPrefix: prefix,
+		OnQuery:        func(qe *workbench.QueryEntry) { logged <- qe },
+	})
+
+	ask(t, s.Addr(), "www.example.com", dns.TypeA)
+	m := new(dns.Msg)
+	m.SetQuestion("missing.example.com.", dns.TypeAAAA)
+	m.SetEdns0(1232, true)
+	exchange(t, "tcp", s.Addr(), "", m, nil)
+
+	clients := []string{}
+	for i := 0; i < 2; i++ {
+		select {
+		case qe := <-logged:
+			clients = append(clients, qe.Client)
+		case <-time.After(time.Second):
+			t.Fatalf("Query %d wasn't logged", i+1)
+		}
+	}
+	return out.String(), clients
+}
+
+func TestQueryLogText(t *testing.T) {
+	out, clients := logQueries(t, "text", "dns: ")
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines in the query log, got %q", out)
+	}
+	for i, want := range []string{
+		fmt.Sprintf(`Query from %s over udp id=\d+ flags=rd \[IN A\] www\.example\.com\. rcode=NOERROR answers=1 size=\d+ latency=\S+$`,
+			regexp.QuoteMeta(clients[0])),
+		fmt.Sprintf(`Query from %s over tcp id=\d+ flags=rd,do \[IN AAAA\] missing\.example\.com\. rcode=NXDOMAIN answers=0 size=\d+ latency=\S+ edns=udp=1232$`,
+			regexp.QuoteMeta(clients[1])),
+	} {
+		if !strings.HasPrefix(lines[i], "dns: ") {
+			t.Errorf("Line %d doesn't start with the prefix: %q", i+1, lines[i])
+		}
+		if !regexp.MustCompile(want).MatchString(lines[i]) {
+			t.Errorf("Line %d is %q, expected it to match %q", i+1, lines[i], want)
+		}
+	}
+}
+
+func TestQueryLogJSON(t *testing.T) {
+	out, clients := logQueries(t, "json", "ignored: ")
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines in the query log, got %q", out)
+	}
+	for i, want := range []workbench.QueryEntry{
+		{Client: clients[0], Protocol: "udp", Flags: []string{"rd"}, Name: "www.example.com.", Type: "A", Class: "IN", Zone: "example.com.", Rcode: "NOERROR", Answers: 1},
+		{Client: clients[1], Protocol: "tcp", Flags: []string{"rd", "do"}, Name: "missing.example.com.", Type: "AAAA", Class: "IN", Zone: "example.com.", EDNS: []string{"udp=1232"}, Rcode: "NXDOMAIN"},
+	} {
+		var got workbench.QueryEntry
+		err := json.Unmarshal([]byte(lines[i]), &got)
+		if err != nil {
+			t.Fatalf("Line %d isn't JSON: %s: %q", i+1, err, lines[i])
+		}
+		if got.Time.IsZero() || got.Size == 0 || got.Latency <= 0 {
+			t.Errorf("Line %d is missing the time, size or latency: %q", i+1, lines[i])
+		}
+		want.Time, want.ID, want.Size, want.Latency = got.Time, got.ID, got.Size, got.Latency
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Line %d is %+v, expected %+v", i+1, got, want)
+		}
+	}
+}
+
+func TestQueryLogFormatError(t *testing.T) {
+	rz, err := workbench.ParseYAML([]byte(exampleZones))
+	if err != nil {
+		t.Fatalf("Failed to parse zones: %s", err)
+	}
+	_, err = workbench.New(rz, workbench.Config{QueryLog: ioutil.Discard, QueryLogFormat: "xml"})
+	if err == nil {
+		t.Errorf("Workbench was created with an unknown query log format")
+	}
+}
@@ -0,0 +1,111 @@
+package workbench_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/github.com/miekg/dns"
+	"github.com/rolandshoemaker/dns-workbench/workbench"
+)
+
+func writeFile(t *testing.T, path, contents string) {
+	t.Helper()
+	err := ioutil.WriteFile(path, []byte(contents), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write %s: %s", path, err)
+	}
+}
+
+func TestReloadFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reload")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "zones.yml")
+	zonesDir := filepath.Join(dir, "zones.d")
+	err = os.Mkdir(zonesDir, 0755)
+	if err != nil {
+		t.Fatalf("Failed to create zone directory: %s", err)
+	}
+	writeFile(t, file, exampleZones)
+
+	rz, err := workbench.LoadFiles([]string{file}, []string{zonesDir})
+	if err != nil {
+		t.Fatalf("Failed to load zones: %s", err)
+	}
+	wb, err := workbench.New(rz, workbench.Config{})
+	if err != nil {
+		t.Fatalf("Failed to create workbench: %s", err)
+	}
+	err = wb.Start()
+	if err != nil {
+		t.Fatalf("Failed to start workbench: %s", err)
+	}
+	defer wb.Stop()
+
+	serving := "192.0.2.1"
+	for _, tc := range []struct {
+		desc  string
+		file  string
+		extra string
+		// the address served afterwards, empty if the reload should fail
+		want string
+	}{
+		{
+			desc: "changed record",
+			file: "zones:\n  example.com:\n    www.example.com:\n      a: [192.0.2.2]\n",
+			want: "192.0.2.2",
+		},
+		{
+			desc: "invalid YAML",
+			file: "zones:\n  example.com: [\n",
+		},
+		{
+			desc: "invalid record",
+			file: "zones:\n  example.com:\n    www.example.com:\n      a: [not-an-address]\n",
+		},
+		{
+			desc:  "zone defined twice",
+			file:  "zones:\n  example.com:\n    www.example.com:\n      a: [192.0.2.3]\n",
+			extra: "zones:\n  example.com:\n    mail.example.com:\n      a: [192.0.2.4]\n",
+		},
+		{
+			desc: "fixed",
+			file: "zones:\n  example.com:\n    www.example.com:\n      a: [192.0.2.5]\n",
+			want: "192.0.2.5",
+		},
+	} {
+		writeFile(t, file, tc.file)
+		extra := filepath.Join(zonesDir, "extra.yml")
+		os.Remove(extra)
+		if tc.extra != "" {
+			writeFile(t, extra, tc.extra)
+		}
+
+		err := wb.ReloadFiles([]string{file}, []string{zonesDir})
+		if tc.want == "" && err == nil {
+			t.Errorf("%s: reload succeeded, expected an error", tc.desc)
+		} else if tc.want != "" && err != nil {
+			t.Errorf("%s: reload failed: %s", tc.desc, err)
+		}
+		if tc.want != "" {
+			serving = tc.want
+		}
+		if got := values(ask(t, wb.Addr(), "www.example.com", dns.TypeA).Answer); !reflect.DeepEqual(got, []string{serving}) {
+			t.Errorf("%s: got %q after reloading, expected %s", tc.desc, got, serving)
+		}
+	}
+
+	// a file that has disappeared also keeps the previous zones
+	err = wb.ReloadFiles([]string{filepath.Join(dir, "missing.yml")}, nil)
+	if err == nil {
+		t.Errorf("Reloading a missing file succeeded")
+	}
+	if got := values(ask(t, wb.Addr(), "www.example.com", dns.TypeA).Answer); !reflect.DeepEqual(got, []string{serving}) {
+		t.Errorf("Got %q after reloading a missing file, expected %s", got, serving)
+	}
+}
@@ -0,0 +1,357 @@
+package workbench
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/github.com/miekg/dns"
+)
+
+// DefaultRoots are the addresses of the root servers of the real DNS tree
+var DefaultRoots = []string{
+	"198.41.0.4",     // a.root-servers.net
+	"170.247.170.2",  // b.root-servers.net
+	"192.33.4.12",    // c.root-servers.net
+	"199.7.91.13",    // d.root-servers.net
+	"192.203.230.10", // e.root-servers.net
+	"192.5.5.241",    // f.root-servers.net
+	"192.112.36.4",   // g.root-servers.net
+	"198.97.190.53",  // h.root-servers.net
+	"192.36.148.17",  // i.root-servers.net
+	"192.58.128.30",  // j.root-servers.net
+	"193.0.14.129",   // k.root-servers.net
+	"199.7.83.42",    // l.root-servers.net
+	"202.12.27.33",   // m.root-servers.net
+}
+
+const (
+	// limits on how much work a single resolution can do
+	maxReferrals = 16
+	maxRestarts  = 8
+	maxDepth     = 4
+)
+
+// LoadRootHints returns the addresses in a root hints file, like the one
+// written by the hierarchy command
+func LoadRootHints(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	addrs := []string{}
+	for t := range dns.ParseZone(f, ".", path) {
+		if t.Error != nil {
+			return nil, t.Error
+		}
+		switch rr := t.RR.(type) {
+		case *dns.A:
+			addrs = append(addrs, rr.A.String())
+		case *dns.AAAA:
+			addrs = append(addrs, rr.AAAA.String())
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("No addresses in root hints")
+	}
+	return addrs, nil
+}
+
+type cacheEntry struct {
+	rcode   int
+	answer  []dns.RR
+	ns      []dns.RR
+	expires time.Time
+}
+
+type delegation struct {
+	servers []string
+	expires time.Time
+}
+
+// Resolver resolves names iteratively starting from the root servers,
+// following referrals and CNAMEs, and caches answers and delegations until
+// their TTLs expire
+type Resolver struct {
+	roots []string
+	port  string
+	// Called with every response received from a nameserver if set
+	Trace func(server string, m *dns.Msg)
+
+	mu          sync.Mutex
+	cache       map[string]*cacheEntry
+	delegations map[string]*delegation
+}
+
+// NewResolver creates a Resolver using the root servers at roots,
+// nameservers whose address doesn't include a port are queried on port,
+// which defaults to 53
+func NewResolver(roots []string, port string) *Resolver {
+	if port == "" {
+		port = "53"
+	}
+	r := &Resolver{
+		port:        port,
+		cache:       make(map[string]*cacheEntry),
+		delegations: make(map[string]*delegation),
+	}
+	for _, a := range roots {
+		r.roots = append(r.roots, r.serverAddr(a))
+	}
+	return r
+}
+
+func (r *Resolver) serverAddr(a string) string {
+	if _, _, err := net.SplitHostPort(a); err == nil {
+		return a
+	}
+	return net.JoinHostPort(strings.Trim(a, "[]"), r.port)
+}
+
+func cacheKey(name string, qType uint16) string {
+	return fmt.Sprintf("%s/%d", strings.ToLower(name), qType)
+}
+
+// cached returns the cached response for name and qType with the TTLs
+// counted down, or nil if there isn't one
+func (r *Resolver) cached(name string, qType uint16) *cacheEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := cacheKey(name, qType)
+	e, present := r.cache[key]
+	if !present {
+		return nil
+	}
+	remaining := e.expires.Sub(time.Now())
+	if remaining <= 0 {
+		delete(r.cache, key)
+		return nil
+	}
+	countDown := func(rrs []dns.RR) []dns.RR {
+		out := []dns.RR{}
+		for _, rr := range rrs {
+			rr = dns.Copy(rr)
+			if ttl := uint32(remaining.Seconds()); ttl < rr.Header().Ttl {
+				rr.Header().Ttl = ttl
+			}
+			out = append(out, rr)
+		}
+		return out
+	}
+	return &cacheEntry{rcode: e.rcode, answer: countDown(e.answer), ns: countDown(e.ns)}
+}
+
+func (r *Resolver) store(name string, qType uint16, e *cacheEntry, ttl uint32) {
+	if ttl == 0 {
+		return
+	}
+	e.expires = time.Now().Add(time.Duration(ttl) * time.Second)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cache[cacheKey(name, qType)] = e
+}
+
+// closest returns the closest enclosing zone of name with a cached
+// delegation and its nameservers, falling back to the root servers
+func (r *Resolver) closest(name string) (string, []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	name = strings.ToLower(name)
+	for off, end := 0, false; !end; off, end = dns.NextLabel(name, off) {
+		d, present := r.delegations[name[off:]]
+		if !present {
+			continue
+		}
+		if time.Now().After(d.expires) {
+			delete(r.delegations, name[off:])
+			continue
+		}
+		return name[off:], d.servers
+	}
+	return ".", r.roots
+}
+
+// Resolve returns the response for name and qType, following any CNAMEs
+func (r *Resolver) Resolve(name string, qType uint16) (*dns.Msg, error) {
+	name = dns.Fqdn(name)
+	m := new(dns.Msg)
+	m.SetQuestion(name, qType)
+	m.Response = true
+	m.RecursionAvailable = true
+	var err error
+	m.Answer, m.Ns, m.Rcode, err = r.resolve(name, qType, 0)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (r *Resolver) resolve(name string, qType uint16, depth int) ([]dns.RR, []dns.RR, int, error) {
+	if depth > maxDepth {
+		return nil, nil, 0, fmt.Errorf("Too many levels of nameserver lookups resolving %s", name)
+	}
+	answer := []dns.RR{}
+	for restarts := 0; restarts <= maxRestarts; restarts++ {
+		e, err := r.lookup(name, qType, depth)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		answer = append(answer, e.answer...)
+		target := cnameTarget(e.answer, name, qType)
+		if target == "" || e.rcode != dns.RcodeSuccess {
+			return answer, e.ns, e.rcode, nil
+		}
+		name = target
+	}
+	return nil, nil, 0, fmt.Errorf("CNAME chain for %s is too long", name)
+}
+
+// cnameTarget follows the CNAMEs for name in answer, returning the name the
+// query should be restarted with or an empty string if answer is complete
+func cnameTarget(answer []dns.RR, name string, qType uint16) string {
+	if qType == dns.TypeCNAME {
+		return ""
+	}
+	current := name
+	for i := 0; i <= len(answer); i++ {
+		next := ""
+		for _, rr := range answer {
+			hdr := rr.Header()
+			if !sameName(hdr.Name, current) {
+				continue
+			}
+			if hdr.Rrtype == qType {
+				return ""
+			}
+			if cname, ok := rr.(*dns.CNAME); ok {
+				next = cname.Target
+			}
+		}
+		if next == "" {
+			break
+		}
+		current = next
+	}
+	if sameName(current, name) {
+		return ""
+	}
+	return current
+}
+
+// lookup returns the answer for name and qType without following CNAMEs
+// outside of what the authoritative server returns
+func (r *Resolver) lookup(name string, qType uint16, depth int) (*cacheEntry, error) {
+	if e := r.cached(name, qType); e != nil {
+		return e, nil
+	}
+	zone, servers := r.closest(name)
+	for i := 0; i < maxReferrals; i++ {
+		resp, server, err := r.query(servers, name, qType)
+		if err != nil {
+			return nil, err
+		}
+
+		child, nsNames, ttl := referral(resp, zone, name)
+		if child == "" || len(resp.Answer) > 0 {
+			e := &cacheEntry{rcode: resp.Rcode, answer: resp.Answer, ns: resp.Ns}
+			cacheTTL, _ := minTTL(resp)
+			r.store(name, qType, e, cacheTTL)
+			return e, nil
+		}
+
+		addrs := []string{}
+		for _, rr := range resp.Extra {
+			// only trust glue from within the zone of the server
+			if !dns.IsSubDomain(zone, rr.Header().Name) || !nsNames[strings.ToLower(rr.Header().Name)] {
+				continue
+			}
+			switch g := rr.(type) {
+			case *dns.A:
+				addrs = append(addrs, r.serverAddr(g.A.String()))
+			case *dns.AAAA:
+				addrs = append(addrs, r.serverAddr(g.AAAA.String()))
+			}
+		}
+		if len(addrs) == 0 {
+			for ns := range nsNames {
+				answer, _, _, err := r.resolve(ns, dns.TypeA, depth+1)
+				if err != nil {
+					continue
+				}
+				for _, rr := range answer {
+					if a, ok := rr.(*dns.A); ok {
+						addrs = append(addrs, r.serverAddr(a.A.String()))
+					}
+				}
+				if len(addrs) > 0 {
+					break
+				}
+			}
+		}
+		if len(addrs) == 0 {
+			return nil, fmt.Errorf("No addresses for the nameservers of %s from %s", child, server)
+		}
+
+		r.mu.Lock()
+		r.delegations[child] = &delegation{servers: addrs, expires: time.Now().Add(time.Duration(ttl) * time.Second)}
+		r.mu.Unlock()
+		zone, servers = child, addrs
+	}
+	return nil, fmt.Errorf("Too many referrals resolving %s", name)
+}
+
+// referral returns the zone resp delegates name to, if it is a referral from
+// a server for zone, along with the lower case nameserver names and the TTL
+// of the NS records
+func referral(resp *dns.Msg, zone, name string) (string, map[string]bool, uint32) {
+	if resp.Rcode != dns.RcodeSuccess || resp.Authoritative {
+		return "", nil, 0
+	}
+	child := ""
+	nsNames := make(map[string]bool)
+	var ttl uint32
+	for _, rr := range resp.Ns {
+		ns, ok := rr.(*dns.NS)
+		if !ok {
+			continue
+		}
+		owner := strings.ToLower(ns.Hdr.Name)
+		if sameName(owner, zone) || !dns.IsSubDomain(zone, owner) || !dns.IsSubDomain(owner, name) {
+			continue
+		}
+		child = owner
+		nsNames[strings.ToLower(ns.Ns)] = true
+		ttl = ns.Hdr.Ttl
+	}
+	return child, nsNames, ttl
+}
+
+// query sends an iterative query for name to each of servers in turn until
+// one gives a usable response
+func (r *Resolver) query(servers []string, name string, qType uint16) (*dns.Msg, string, error) {
+	req := new(dns.Msg)
+	req.SetQuestion(name, qType)
+	req.RecursionDesired = false
+	req.SetEdns0(dns.DefaultMsgSize, false)
+	err := fmt.Errorf("No nameservers to query for %s", name)
+	for _, s := range servers {
+		resp, _, exchangeErr := exchange(req, []string{s})
+		if exchangeErr != nil {
+			err = exchangeErr
+			continue
+		}
+		if r.Trace != nil {
+			r.Trace(s, resp)
+		}
+		switch resp.Rcode {
+		case dns.RcodeSuccess, dns.RcodeNameError, dns.RcodeNXRrset:
+			return resp, s, nil
+		}
+		err = fmt.Errorf("%s answered %s for %s", s, dns.RcodeToString[resp.Rcode], name)
+	}
+	return nil, "", err
+}
@@ -0,0 +1,77 @@
+package workbench
+
+import (
+	"testing"
+
+	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/github.com/miekg/dns"
+)
+
+func TestCNAMETarget(t *testing.T) {
+	for _, tc := range []struct {
+		desc   string
+		answer []string
+		qType  uint16
+		want   string
+	}{
+		{
+			desc:  "empty answer",
+			qType: dns.TypeA,
+		},
+		{
+			desc:   "direct answer",
+			answer: []string{"a.example. A 192.0.2.1"},
+			qType:  dns.TypeA,
+		},
+		{
+			desc:   "dangling CNAME",
+			answer: []string{"a.example. CNAME b.example."},
+			qType:  dns.TypeA,
+			want:   "b.example.",
+		},
+		{
+			desc:   "complete chain",
+			answer: []string{"a.example. CNAME b.example.", "b.example. A 192.0.2.1"},
+			qType:  dns.TypeA,
+		},
+		{
+			desc:   "dangling chain",
+			answer: []string{"a.example. CNAME b.example.", "b.example. CNAME c.other."},
+			qType:  dns.TypeA,
+			want:   "c.other.",
+		},
+		{
+			desc:   "chain out of order",
+			answer: []string{"b.example. CNAME c.other.", "a.example. CNAME b.example."},
+			qType:  dns.TypeA,
+			want:   "c.other.",
+		},
+		{
+			desc:   "owner case differs",
+			answer: []string{"A.Example. CNAME b.example.", "B.EXAMPLE. A 192.0.2.1"},
+			qType:  dns.TypeA,
+		},
+		{
+			desc:   "answer for another type",
+			answer: []string{"a.example. CNAME b.example.", "b.example. AAAA 2001:db8::1"},
+			qType:  dns.TypeA,
+			want:   "b.example.",
+		},
+		{
+			desc:   "CNAME query",
+			answer: []string{"a.example. CNAME b.example."},
+			qType:  dns.TypeCNAME,
+		},
+	} {
+		answer := []dns.RR{}
+		for _, s := range tc.answer {
+			rr, err := dns.NewRR(s)
+			if err != nil {
+				t.Fatalf("%s: bad record %q: %s", tc.desc, s, err)
+			}
+			answer = append(answer, rr)
+		}
+		if got := cnameTarget(answer, "a.example.", tc.qType); got != tc.want {
+			t.Errorf("%s: cnameTarget = %q, expected %q", tc.desc, got, tc.want)
+		}
+	}
+}
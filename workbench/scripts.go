@@ -0,0 +1,171 @@
+package workbench
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/github.com/miekg/dns"
+)
+
+// Script modes control how a scripted name picks the next response
+const (
+	// advance once per query and keep returning the last response
+	modeSequence = "sequence"
+	// advance once per query and wrap around after the last response
+	modeRoundRobin = "round-robin"
+	// pick a random response for every query
+	modeRandom = "random"
+	// pin each client to a single response, handed out round-robin
+	modeSticky = "sticky"
+)
+
+// RawResponse is a single scripted response
+type RawResponse struct {
+	Rcode   string   `yaml:"rcode,omitempty" json:"rcode"`
+	Records []string `yaml:"records,omitempty" json:"records"`
+}
+
+// RawScript is an ordered list of responses for a name and type
+type RawScript struct {
+	Mode      string        `yaml:"mode,omitempty" json:"mode"`
+	Responses []RawResponse `yaml:"responses,omitempty" json:"responses"`
+}
+
+type response struct {
+	rcode   int
+	records []dns.RR
+}
+
+type script struct {
+	mu        sync.Mutex
+	mode      string
+	responses []response
+	pos       int
+	clients   map[string]int
+}
+
+type scripts map[string]map[uint16]*script
+
+func constructScripts(rz RawZones) (scripts, error) {
+	s := make(scripts)
+	for host, types := range rz.Scripts {
+		host = dns.Fqdn(host)
+		if _, present := s[host]; !present {
+			s[host] = make(map[uint16]*script)
+		}
+
+		for typeStr, rs := range types {
+			rType, present := dns.StringToType[strings.ToUpper(typeStr)]
+			if !present {
+				return nil, fmt.Errorf("Invalid record type")
+			}
+
+			mode := strings.ToLower(rs.Mode)
+			switch mode {
+			case "":
+				mode = modeSequence
+			case modeSequence, modeRoundRobin, modeRandom, modeSticky:
+			default:
+				return nil, fmt.Errorf("Invalid script mode for %s: %s", host, rs.Mode)
+			}
+			if len(rs.Responses) == 0 {
+				return nil, fmt.Errorf("Script for %s has no responses", host)
+			}
+
+			sc := &script{mode: mode, clients: make(map[string]int)}
+			for _, raw := range rs.Responses {
+				resp := response{rcode: dns.RcodeSuccess}
+				if raw.Rcode != "" {
+					rcode, present := dns.StringToRcode[strings.ToUpper(raw.Rcode)]
+					if !present {
+						return nil, fmt.Errorf("Invalid rcode for %s: %s", host, raw.Rcode)
+					}
+					resp.rcode = rcode
+				}
+				for _, presentation := range raw.Records {
+					rr, err := newRecord(host, typeStr, presentation)
+					if err != nil {
+						return nil, err
+					}
+					resp.records = append(resp.records, rr)
+				}
+				sc.responses = append(sc.responses, resp)
+			}
+			s[host][rType] = sc
+		}
+	}
+	return s, nil
+}
+
+func (s scripts) lookup(name string, qType uint16) *script {
+	if types, present := s[name]; present {
+		return types[qType]
+	}
+	return nil
+}
+
+// next returns the response for the current query and advances the script
+func (s *script) next(client string) response {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var i int
+	switch s.mode {
+	case modeSequence:
+		i = s.pos
+		if s.pos < len(s.responses)-1 {
+			s.pos++
+		}
+	case modeRoundRobin:
+		i = s.pos
+		s.pos = (s.pos + 1) % len(s.responses)
+	case modeRandom:
+		i = rand.Intn(len(s.responses))
+	case modeSticky:
+		var present bool
+		if i, present = s.clients[client]; !present {
+			i = s.pos
+			s.clients[client] = i
+			s.pos = (s.pos + 1) % len(s.responses)
+		}
+	}
+	return s.responses[i]
+}
+
+// reset moves the script back to its first response and forgets which
+// response each client was given
+func (s *script) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pos = 0
+	s.clients = make(map[string]int)
+}
+
+// ResetScripts moves every script back to its first response, sticky clients
+// are assigned a response again on their next query
+func (wb *Workbench) ResetScripts() {
+	wb.mu.RLock()
+	for _, v := range wb.views {
+		for _, types := range v.s {
+			for _, sc := range types {
+				sc.reset()
+			}
+		}
+	}
+	wb.mu.RUnlock()
+	err := wb.SaveState()
+	if err != nil {
+		wb.l.Printf("Failed to save state: %s\n", err)
+	}
+}
+
+func clientAddr(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
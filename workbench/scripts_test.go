@@ -0,0 +1,142 @@
+package workbench_test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/github.com/miekg/dns"
+	"github.com/rolandshoemaker/dns-workbench/workbench/workbenchtest"
+)
+
+const scriptZones = `
+zones:
+  example.com:
+    example.com:
+      a: [192.0.2.1]
+scripts:
+  s.example.com:
+    a:
+      mode: %s
+      responses:
+        - rcode: nxdomain
+        - records: [192.0.2.10]
+        - rcode: servfail
+        - records: [192.0.2.11, 192.0.2.12]
+`
+
+type scriptStep struct {
+	from    string
+	rcode   int
+	answers []string
+}
+
+var (
+	stepNXDomain = scriptStep{rcode: dns.RcodeNameError, answers: []string{}}
+	stepFirst    = scriptStep{rcode: dns.RcodeSuccess, answers: []string{"192.0.2.10"}}
+	stepServFail = scriptStep{rcode: dns.RcodeServerFailure, answers: []string{}}
+	stepLast     = scriptStep{rcode: dns.RcodeSuccess, answers: []string{"192.0.2.11", "192.0.2.12"}}
+)
+
+func from(addr string, step scriptStep) scriptStep {
+	step.from = addr
+	return step
+}
+
+func TestScripts(t *testing.T) {
+	for _, tc := range []struct {
+		mode  string
+		steps []scriptStep
+	}{
+		{
+			mode:  "sequence",
+			steps: []scriptStep{stepNXDomain, stepFirst, stepServFail, stepLast, stepLast, stepLast},
+		},
+		{
+			// sequence is the default
+			mode:  `""`,
+			steps: []scriptStep{stepNXDomain, stepFirst, stepServFail, stepLast, stepLast},
+		},
+		{
+			mode:  "round-robin",
+			steps: []scriptStep{stepNXDomain, stepFirst, stepServFail, stepLast, stepNXDomain, stepFirst},
+		},
+		{
+			// each client keeps the response it was first given
+			mode: "sticky",
+			steps: []scriptStep{
+				from("127.0.0.1", stepNXDomain),
+				from("127.0.0.2", stepFirst),
+				from("127.0.0.1", stepNXDomain),
+				from("127.0.0.3", stepServFail),
+				from("127.0.0.2", stepFirst),
+				from("127.0.0.4", stepLast),
+				from("127.0.0.5", stepNXDomain),
+				from("127.0.0.3", stepServFail),
+			},
+		},
+	} {
+		s := workbenchtest.NewServer(t, fmt.Sprintf(scriptZones, tc.mode))
+		for i, step := range tc.steps {
+			m := new(dns.Msg)
+			m.SetQuestion("s.example.com.", dns.TypeA)
+			resp := exchange(t, "udp", s.Addr(), step.from, m, nil)
+			if resp.Rcode != step.rcode || !reflect.DeepEqual(values(resp.Answer), step.answers) {
+				t.Errorf("%s: query %d from %s got %s %q, expected %s %q", tc.mode, i+1, step.from,
+					dns.RcodeToString[resp.Rcode], values(resp.Answer), dns.RcodeToString[step.rcode], step.answers)
+			}
+		}
+		// other types and names aren't scripted
+		if resp := ask(t, s.Addr(), "s.example.com", dns.TypeAAAA); resp.Rcode != dns.RcodeNameError {
+			t.Errorf("%s: unscripted type got %s, expected NXDOMAIN", tc.mode, dns.RcodeToString[resp.Rcode])
+		}
+		if resp := ask(t, s.Addr(), "example.com", dns.TypeA); !reflect.DeepEqual(values(resp.Answer), []string{"192.0.2.1"}) {
+			t.Errorf("%s: unscripted name got %q", tc.mode, values(resp.Answer))
+		}
+	}
+}
+
+func TestScriptRandom(t *testing.T) {
+	s := workbenchtest.NewServer(t, fmt.Sprintf(scriptZones, "random"))
+	seen := make(map[int]int)
+	for i := 0; i < 100; i++ {
+		resp := ask(t, s.Addr(), "s.example.com", dns.TypeA)
+		seen[resp.Rcode]++
+		if resp.Rcode == dns.RcodeSuccess && len(resp.Answer) != 1 && len(resp.Answer) != 2 {
+			t.Fatalf("Unexpected answers %q", values(resp.Answer))
+		}
+	}
+	for _, rcode := range []int{dns.RcodeNameError, dns.RcodeSuccess, dns.RcodeServerFailure} {
+		if seen[rcode] == 0 {
+			t.Errorf("%s was never returned in 100 queries: %v", dns.RcodeToString[rcode], seen)
+		}
+	}
+}
+
+func TestScriptErrors(t *testing.T) {
+	for _, tc := range []struct {
+		desc  string
+		zones string
+	}{
+		{
+			desc:  "unknown mode",
+			zones: fmt.Sprintf(scriptZones, "backwards"),
+		},
+		{
+			desc:  "no responses",
+			zones: "scripts:\n  s.example.com:\n    a:\n      mode: sequence\n",
+		},
+		{
+			desc:  "unknown rcode",
+			zones: "scripts:\n  s.example.com:\n    a:\n      responses:\n        - rcode: maybe\n",
+		},
+		{
+			desc:  "bad record",
+			zones: "scripts:\n  s.example.com:\n    a:\n      responses:\n        - records: [not-an-address]\n",
+		},
+	} {
+		if err := zonesError(tc.zones); err == nil {
+			t.Errorf("%s: workbench was created, expected an error", tc.desc)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package workbench
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/github.com/miekg/dns"
+)
+
+// stateFile is the name of the file the workbench state is kept in inside
+// the state directory
+const stateFile = "state.json"
+
+// scriptState is the position of a script, view is empty for scripts defined
+// at the top level
+type scriptState struct {
+	View    string         `json:"view"`
+	Host    string         `json:"host"`
+	Type    string         `json:"type"`
+	Pos     int            `json:"pos"`
+	Clients map[string]int `json:"clients,omitempty"`
+}
+
+// state is everything needed to restore a workbench after a restart
+type state struct {
+	Zones   RawZones                     `json:"zones"`
+	Serials map[string]map[string]uint32 `json:"serials"`
+	Scripts []scriptState                `json:"scripts"`
+}
+
+// loadState reads the state saved in dir, returning nil if there isn't any
+func loadState(dir string) (*state, error) {
+	content, err := ioutil.ReadFile(filepath.Join(dir, stateFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	st := &state{}
+	err = json.Unmarshal(content, st)
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// assignSerials returns the SOA serials to use for rz keyed by view and zone
+// name, zones that haven't changed keep their current serial while new or
+// changed zones get a new one
+func (wb *Workbench) assignSerials(rz RawZones) map[string]map[string]uint32 {
+	wb.mu.RLock()
+	defer wb.mu.RUnlock()
+	old := rawViews(wb.rz)
+	serials := make(map[string]map[string]uint32)
+	for name, raw := range rawViews(rz) {
+		serials[name] = make(map[string]uint32)
+		for zone, hosts := range raw.Zones {
+			key := strings.ToLower(dns.Fqdn(zone))
+			prev, present := wb.serials[name][key]
+			oldHosts := old[name].Zones[zoneKey(old[name].Zones, zone)]
+			if present && reflect.DeepEqual(oldHosts, hosts) {
+				serials[name][key] = prev
+				continue
+			}
+			serials[name][key] = newSerial(prev)
+		}
+	}
+	return serials
+}
+
+func (wb *Workbench) scriptStates() []scriptState {
+	states := []scriptState{}
+	for _, v := range wb.views {
+		for host, types := range v.s {
+			for rType, sc := range types {
+				sc.mu.Lock()
+				if sc.pos != 0 || len(sc.clients) > 0 {
+					ss := scriptState{
+						View:    v.name,
+						Host:    host,
+						Type:    dns.TypeToString[rType],
+						Pos:     sc.pos,
+						Clients: make(map[string]int, len(sc.clients)),
+					}
+					for client, i := range sc.clients {
+						ss.Clients[client] = i
+					}
+					states = append(states, ss)
+				}
+				sc.mu.Unlock()
+			}
+		}
+	}
+	return states
+}
+
+// restoreScripts moves scripts to the positions in states, states for
+// scripts that no longer exist or have fewer responses are ignored
+func (wb *Workbench) restoreScripts(states []scriptState) {
+	wb.mu.RLock()
+	defer wb.mu.RUnlock()
+	restoreScriptStates(wb.views, states)
+}
+
+func restoreScriptStates(views []*view, states []scriptState) {
+	for _, ss := range states {
+		rType, present := dns.StringToType[strings.ToUpper(ss.Type)]
+		if !present {
+			continue
+		}
+		for _, v := range views {
+			if v.name != ss.View {
+				continue
+			}
+			sc := v.s.lookup(ss.Host, rType)
+			if sc == nil {
+				continue
+			}
+			sc.mu.Lock()
+			if ss.Pos < len(sc.responses) {
+				sc.pos = ss.Pos
+			}
+			for client, i := range ss.Clients {
+				if i < len(sc.responses) {
+					sc.clients[client] = i
+				}
+			}
+			sc.mu.Unlock()
+		}
+	}
+}
+
+// rawScript returns the definition of the script for host and rrType in the
+// view called view
+func rawScript(rz RawZones, view, host, rrType string) (RawScript, bool) {
+	for h, types := range rawViews(rz)[view].Scripts {
+		if dns.Fqdn(h) != host {
+			continue
+		}
+		for t, rs := range types {
+			if strings.EqualFold(t, rrType) {
+				return rs, true
+			}
+		}
+	}
+	return RawScript{}, false
+}
+
+// unchangedScripts returns the states of scripts whose definition is the same
+// in old and updated
+func unchangedScripts(states []scriptState, old, updated RawZones) []scriptState {
+	kept := []scriptState{}
+	for _, ss := range states {
+		before, _ := rawScript(old, ss.View, ss.Host, ss.Type)
+		after, present := rawScript(updated, ss.View, ss.Host, ss.Type)
+		if present && reflect.DeepEqual(before, after) {
+			kept = append(kept, ss)
+		}
+	}
+	return kept
+}
+
+// SaveState atomically writes the zones, SOA serials and script positions to
+// the state directory, it does nothing if no state directory was configured
+func (wb *Workbench) SaveState() error {
+	if wb.stateDir == "" {
+		return nil
+	}
+	wb.saveMu.Lock()
+	defer wb.saveMu.Unlock()
+
+	wb.mu.RLock()
+	st := state{
+		Zones:   wb.rz,
+		Serials: wb.serials,
+		Scripts: wb.scriptStates(),
+	}
+	content, err := json.MarshalIndent(st, "", "  ")
+	wb.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(wb.stateDir, "."+stateFile)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(content)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(wb.stateDir, stateFile))
+}
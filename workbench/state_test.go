@@ -0,0 +1,171 @@
+package workbench_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/github.com/miekg/dns"
+	"github.com/rolandshoemaker/dns-workbench/workbench"
+	"github.com/rolandshoemaker/dns-workbench/workbench/workbenchtest"
+)
+
+const stateZonesKey = "257 3 13 mdsswUyr3DPW132mOi8V9xESWE8jTo0dxCjjnopKl+GqJxpVXckHAeF+KkxLbxILfDLUT0rAK9iUzy1L53eKGQ=="
+
+const stateZones = `
+zones:
+  example.com:
+    www.example.com:
+      a: [192.0.2.1]
+      dnskey: ["` + stateZonesKey + `"]
+scripts:
+  s.example.com:
+    a:
+      responses:
+        - records: [192.0.2.10]
+        - records: [192.0.2.11]
+        - records: [192.0.2.12]
+  sticky.example.com:
+    a:
+      mode: sticky
+      responses:
+        - records: [192.0.2.20]
+        - records: [192.0.2.21]
+`
+
+// serial returns the SOA serial of zone
+func serial(t *testing.T, addr, zone string) uint32 {
+	t.Helper()
+	resp := ask(t, addr, zone, dns.TypeSOA)
+	if len(resp.Answer) != 1 {
+		t.Fatalf("Expected a SOA record for %s, got %q", zone, values(resp.Answer))
+	}
+	return resp.Answer[0].(*dns.SOA).Serial
+}
+
+// scripted returns the answers to a query for name A from the address from
+func scripted(t *testing.T, addr, from, name string) []string {
+	t.Helper()
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), dns.TypeA)
+	return values(exchange(t, "udp", addr, from, m, nil).Answer)
+}
+
+func TestStateRoundTrip(t *testing.T) {
+	dir := tempDir(t)
+	wb := newWorkbench(t, stateZones, workbench.Config{StateDir: dir})
+	err := wb.AddRecords("example.org", "www.example.org", "a", "192.0.2.2")
+	if err != nil {
+		t.Fatalf("Failed to add records: %s", err)
+	}
+	scripted(t, wb.Addr(), "", "s.example.com")
+	scripted(t, wb.Addr(), "127.0.0.1", "sticky.example.com")
+	scripted(t, wb.Addr(), "127.0.0.2", "sticky.example.com")
+	serials := map[string]uint32{
+		"example.com": serial(t, wb.Addr(), "example.com"),
+		"example.org": serial(t, wb.Addr(), "example.org"),
+	}
+	err = wb.SaveState()
+	if err != nil {
+		t.Fatalf("Failed to save state: %s", err)
+	}
+	err = wb.Stop()
+	if err != nil {
+		t.Fatalf("Failed to stop workbench: %s", err)
+	}
+
+	// the saved state is served rather than the zones passed to New
+	restored := newWorkbench(t, exampleZones, workbench.Config{StateDir: dir})
+	defer restored.Stop()
+	addr := restored.Addr()
+	for _, tc := range []struct {
+		desc  string
+		name  string
+		qType uint16
+		want  []string
+	}{
+		{"zone from the file", "www.example.com", dns.TypeA, []string{"192.0.2.1"}},
+		{"zone added through the API", "www.example.org", dns.TypeA, []string{"192.0.2.2"}},
+		{"DNSKEY records", "www.example.com", dns.TypeDNSKEY, []string{stateZonesKey}},
+	} {
+		if got := values(ask(t, addr, tc.name, tc.qType).Answer); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %q, expected %q", tc.desc, got, tc.want)
+		}
+	}
+	for zone, want := range serials {
+		if got := serial(t, addr, zone); got != want {
+			t.Errorf("%s: got serial %d after restoring, expected %d", zone, got, want)
+		}
+	}
+	for _, tc := range []struct {
+		desc string
+		from string
+		name string
+		want string
+	}{
+		{"sequence", "", "s.example.com", "192.0.2.11"},
+		{"sticky client", "127.0.0.2", "sticky.example.com", "192.0.2.21"},
+		{"sticky client", "127.0.0.1", "sticky.example.com", "192.0.2.20"},
+		// the next client carries on from the saved position
+		{"new sticky client", "127.0.0.3", "sticky.example.com", "192.0.2.20"},
+	} {
+		if got := scripted(t, addr, tc.from, tc.name); !reflect.DeepEqual(got, []string{tc.want}) {
+			t.Errorf("%s: got %q after restoring, expected %s", tc.desc, got, tc.want)
+		}
+	}
+}
+
+func TestResetScripts(t *testing.T) {
+	dir := tempDir(t)
+	s := workbenchtest.NewServerWithConfig(t, stateZones, workbench.Config{StateDir: dir})
+	api := httptest.NewServer(s.APIHandler())
+	defer api.Close()
+
+	for _, reset := range []struct {
+		desc string
+		do   func()
+	}{
+		{"ResetScripts", s.ResetScripts},
+		{"API", func() {
+			resp, err := http.Post(api.URL+"/api/scripts/reset", "", nil)
+			if err != nil {
+				t.Fatalf("Reset request failed: %s", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("Reset request got status %d", resp.StatusCode)
+			}
+		}},
+	} {
+		scripted(t, s.Addr(), "", "s.example.com")
+		scripted(t, s.Addr(), "", "s.example.com")
+		scripted(t, s.Addr(), "127.0.0.1", "sticky.example.com")
+		reset.do()
+
+		for _, tc := range []struct {
+			from string
+			name string
+			want string
+		}{
+			{"", "s.example.com", "192.0.2.10"},
+			{"", "s.example.com", "192.0.2.11"},
+			// sticky clients are assigned a response again
+			{"127.0.0.2", "sticky.example.com", "192.0.2.20"},
+			{"127.0.0.1", "sticky.example.com", "192.0.2.21"},
+		} {
+			if got := scripted(t, s.Addr(), tc.from, tc.name); !reflect.DeepEqual(got, []string{tc.want}) {
+				t.Errorf("%s: %s from %q got %q after resetting, expected %s", reset.desc, tc.name, tc.from, got, tc.want)
+			}
+		}
+	}
+
+	// the reset is saved
+	scripted(t, s.Addr(), "", "s.example.com")
+	s.ResetScripts()
+	restored := newWorkbench(t, exampleZones, workbench.Config{StateDir: dir})
+	defer restored.Stop()
+	if got := scripted(t, restored.Addr(), "", "s.example.com"); !reflect.DeepEqual(got, []string{"192.0.2.10"}) {
+		t.Errorf("Got %q after restoring a reset, expected the first response", got)
+	}
+}
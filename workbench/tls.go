@@ -0,0 +1,226 @@
+package workbench
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/github.com/miekg/dns"
+)
+
+// SelfSignedCertificate generates a certificate for hosts, which can be names
+// or IP addresses, that is valid for a year. It's only meant for testing.
+func SelfSignedCertificate(hosts []string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "dns-workbench"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// tlsServer serves DNS over TLS (RFC 7858), the vendored DNS server only
+// works with plain TCP listeners
+type tlsServer struct {
+	l        net.Listener
+	handler  dns.Handler
+	tsig     map[string]string
+	rTimeout time.Duration
+	iTimeout time.Duration
+
+	mu      sync.Mutex
+	closing bool
+	conns   map[net.Conn]bool
+}
+
+func newTLSServer(l net.Listener, config *tls.Config, handler dns.Handler, tsig map[string]string, rTimeout, iTimeout time.Duration) *tlsServer {
+	return &tlsServer{
+		l:        tls.NewListener(l, config),
+		handler:  handler,
+		tsig:     tsig,
+		rTimeout: rTimeout,
+		iTimeout: iTimeout,
+		conns:    make(map[net.Conn]bool),
+	}
+}
+
+// serve accepts connections until shutdown is called
+func (s *tlsServer) serve() error {
+	for {
+		conn, err := s.l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if s.closing {
+				return nil
+			}
+			return err
+		}
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+// shutdown stops accepting connections and closes idle ones, connections
+// with a query in progress are closed once it is answered
+func (s *tlsServer) shutdown() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closing = true
+	s.l.Close()
+	for conn := range s.conns {
+		conn.SetReadDeadline(time.Now())
+	}
+}
+
+func (s *tlsServer) serveConn(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+
+	for {
+		s.mu.Lock()
+		closing := s.closing
+		s.mu.Unlock()
+		if closing {
+			return
+		}
+
+		conn.SetReadDeadline(time.Now().Add(s.iTimeout))
+		var length uint16
+		err := binary.Read(conn, binary.BigEndian, &length)
+		if err != nil {
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(s.rTimeout))
+		buf := make([]byte, length)
+		_, err = io.ReadFull(conn, buf)
+		if err != nil {
+			return
+		}
+		req := new(dns.Msg)
+		err = req.Unpack(buf)
+		if err != nil {
+			return
+		}
+
+		w := &tlsWriter{conn: conn}
+		w.verify(s.tsig, buf, req)
+		s.handler.ServeDNS(w, req)
+		if w.closed {
+			return
+		}
+	}
+}
+
+// tsigState verifies and signs messages for dns.ResponseWriter
+// implementations that don't use the vendored server
+type tsigState struct {
+	tsig           map[string]string
+	tsigStatus     error
+	tsigTimersOnly bool
+	tsigRequestMAC string
+}
+
+// verify checks the TSIG on req, whose wire format is buf, if there is one
+func (ts *tsigState) verify(secrets map[string]string, buf []byte, req *dns.Msg) {
+	ts.tsig = secrets
+	if t := req.IsTsig(); t != nil {
+		secret, present := secrets[t.Hdr.Name]
+		if !present {
+			ts.tsigStatus = dns.ErrSecret
+		} else {
+			ts.tsigStatus = dns.TsigVerify(buf, secret, "", false)
+		}
+		ts.tsigRequestMAC = t.MAC
+	}
+}
+
+// pack returns the wire format of m, signing it if it has a TSIG record
+func (ts *tsigState) pack(m *dns.Msg) ([]byte, error) {
+	if t := m.IsTsig(); t != nil && ts.tsig != nil {
+		data, mac, err := dns.TsigGenerate(m, ts.tsig[t.Hdr.Name], ts.tsigRequestMAC, ts.tsigTimersOnly)
+		if err != nil {
+			return nil, err
+		}
+		ts.tsigRequestMAC = mac
+		return data, nil
+	}
+	return m.Pack()
+}
+
+func (ts *tsigState) TsigStatus() error     { return ts.tsigStatus }
+func (ts *tsigState) TsigTimersOnly(b bool) { ts.tsigTimersOnly = b }
+
+// tlsWriter is a dns.ResponseWriter for a DNS over TLS connection
+type tlsWriter struct {
+	tsigState
+	conn   net.Conn
+	closed bool
+}
+
+func (w *tlsWriter) LocalAddr() net.Addr  { return w.conn.LocalAddr() }
+func (w *tlsWriter) RemoteAddr() net.Addr { return w.conn.RemoteAddr() }
+
+func (w *tlsWriter) WriteMsg(m *dns.Msg) error {
+	data, err := w.pack(m)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func (w *tlsWriter) Write(m []byte) (int, error) {
+	buf := make([]byte, 2, len(m)+2)
+	binary.BigEndian.PutUint16(buf, uint16(len(m)))
+	_, err := w.conn.Write(append(buf, m...))
+	if err != nil {
+		return 0, err
+	}
+	return len(m), nil
+}
+
+func (w *tlsWriter) Close() error {
+	w.closed = true
+	return w.conn.Close()
+}
+
+func (w *tlsWriter) Hijack() {}
@@ -0,0 +1,114 @@
+package workbench_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/github.com/miekg/dns"
+	"github.com/rolandshoemaker/dns-workbench/workbench"
+)
+
+// exchangeStream sends m over the stream connection c, using the two byte
+// length prefix, and returns the response. The vendored dns.Conn only
+// supports plain TCP and UDP connections.
+func exchangeStream(t *testing.T, c net.Conn, m *dns.Msg) *dns.Msg {
+	t.Helper()
+	data, err := m.Pack()
+	if err != nil {
+		t.Fatalf("Failed to pack query: %s", err)
+	}
+	buf := make([]byte, 2, len(data)+2)
+	binary.BigEndian.PutUint16(buf, uint16(len(data)))
+	_, err = c.Write(append(buf, data...))
+	if err != nil {
+		t.Fatalf("Failed to send query: %s", err)
+	}
+	var length uint16
+	err = binary.Read(c, binary.BigEndian, &length)
+	if err != nil {
+		t.Fatalf("Failed to read response: %s", err)
+	}
+	data = make([]byte, length)
+	_, err = io.ReadFull(c, data)
+	if err != nil {
+		t.Fatalf("Failed to read response: %s", err)
+	}
+	resp := new(dns.Msg)
+	err = resp.Unpack(data)
+	if err != nil {
+		t.Fatalf("Failed to unpack response: %s", err)
+	}
+	return resp
+}
+
+func TestTLS(t *testing.T) {
+	cert, err := workbench.SelfSignedCertificate([]string{"127.0.0.1", "dns.example"})
+	if err != nil {
+		t.Fatalf("Failed to generate certificate: %s", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %s", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+
+	logged := make(chan *workbench.QueryEntry, 2)
+	wb := newWorkbench(t, exampleZones, workbench.Config{
+		Listeners: []workbench.Listener{{Addr: "127.0.0.1:0", Nets: []string{"tls"}}},
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+		OnQuery:   func(qe *workbench.QueryEntry) { logged <- qe },
+	})
+	defer wb.Stop()
+
+	c, err := tls.Dial("tcp", wb.Addr(), &tls.Config{RootCAs: roots, ServerName: "dns.example"})
+	if err != nil {
+		t.Fatalf("Failed to connect: %s", err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(2 * time.Second))
+
+	// the connection is kept open between queries
+	for i := 0; i < 2; i++ {
+		m := new(dns.Msg)
+		m.SetQuestion("www.example.com.", dns.TypeA)
+		resp := exchangeStream(t, c, m)
+		if resp.Id != m.Id {
+			t.Errorf("Got response ID %d, expected %d", resp.Id, m.Id)
+		}
+		if got := values(resp.Answer); !reflect.DeepEqual(got, []string{"192.0.2.1"}) {
+			t.Errorf("Query %d: got %q, expected 192.0.2.1", i, got)
+		}
+		select {
+		case qe := <-logged:
+			if qe.Protocol != "tls" {
+				t.Errorf("Query logged with protocol %q, expected tls", qe.Protocol)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Query wasn't logged")
+		}
+	}
+}
+
+func TestTLSWithoutConfig(t *testing.T) {
+	rz, err := workbench.ParseYAML([]byte(exampleZones))
+	if err != nil {
+		t.Fatalf("Failed to parse zones: %s", err)
+	}
+	wb, err := workbench.New(rz, workbench.Config{
+		Listeners: []workbench.Listener{{Addr: "127.0.0.1:0", Nets: []string{"tls"}}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create workbench: %s", err)
+	}
+	if err := wb.Start(); err == nil {
+		wb.Stop()
+		t.Fatalf("Started a TLS listener without a TLS config")
+	}
+}
@@ -0,0 +1,194 @@
+package workbench
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Modes for UpdateZones
+const (
+	// replace every definition with the ones in the update
+	ModeReplace = "replace"
+	// add or replace only the definitions in the update
+	ModeMerge = "merge"
+	// remove the zones named in the update
+	ModeDelete = "delete"
+)
+
+// ErrETagMismatch is returned by UpdateZones when the zones have changed since
+// the ETag it was passed was issued
+var ErrETagMismatch = errors.New("Zones have changed since the ETag was issued")
+
+// ETag returns an identifier for the zone definitions currently being served
+// which changes whenever they do
+func (wb *Workbench) ETag() string {
+	wb.mu.RLock()
+	defer wb.mu.RUnlock()
+	return etag(wb.rz)
+}
+
+func etag(rz RawZones) string {
+	// maps are marshalled with sorted keys so this is stable
+	content, _ := json.Marshal(rz)
+	return fmt.Sprintf("\"%x\"", sha1.Sum(content))
+}
+
+// UpdateZones changes the zones being served according to mode, if ifMatch
+// isn't empty or * and doesn't match the current ETag ErrETagMismatch is
+// returned and nothing is changed
+func (wb *Workbench) UpdateZones(mode string, rz RawZones, ifMatch string) error {
+	wb.editMu.Lock()
+	defer wb.editMu.Unlock()
+	if ifMatch != "" && ifMatch != "*" && ifMatch != wb.ETag() {
+		return ErrETagMismatch
+	}
+	rz, err := wb.updatedZones(mode, rz)
+	if err != nil {
+		return err
+	}
+	return wb.setZones(rz)
+}
+
+// PreviewUpdate returns the changes UpdateZones would make without applying
+// them, returning an error if the update would fail
+func (wb *Workbench) PreviewUpdate(mode string, rz RawZones, ifMatch string) ([]RRsetChange, error) {
+	wb.editMu.Lock()
+	defer wb.editMu.Unlock()
+	if ifMatch != "" && ifMatch != "*" && ifMatch != wb.ETag() {
+		return nil, ErrETagMismatch
+	}
+	updated, err := wb.updatedZones(mode, rz)
+	if err != nil {
+		return nil, err
+	}
+	_, err = constructViews(updated, wb.name, nil)
+	if err != nil {
+		return nil, err
+	}
+	return Diff(wb.Zones(), updated)
+}
+
+func validMode(mode string) bool {
+	switch mode {
+	case ModeReplace, ModeMerge, ModeDelete, "":
+		return true
+	}
+	return false
+}
+
+// updatedZones returns the zones that would be served after applying rz
+// according to mode
+func (wb *Workbench) updatedZones(mode string, rz RawZones) (RawZones, error) {
+	switch mode {
+	case ModeReplace, "":
+		return rz, nil
+	case ModeMerge:
+		return mergeZones(wb.Zones(), rz), nil
+	case ModeDelete:
+		return deleteZones(wb.Zones(), rz)
+	}
+	return rz, fmt.Errorf("Invalid reload mode: %s", mode)
+}
+
+// mergeZones returns base with the zones, scripts, ordering policies, subnets
+// and views in rz added or replaced and the policy zones in rz added
+func mergeZones(base, rz RawZones) RawZones {
+	merged := RawZones{
+		Zones:    make(map[string]map[string]map[string][]string),
+		Scripts:  make(map[string]map[string]RawScript),
+		Ordering: make(map[string]RawZoneOrder),
+		Subnets:  make(map[string]map[string][]RawSubnet),
+	}
+	for zone, hosts := range base.Zones {
+		merged.Zones[zone] = hosts
+	}
+	for zone, hosts := range rz.Zones {
+		delete(merged.Zones, zoneKey(merged.Zones, zone))
+		merged.Zones[zone] = hosts
+	}
+
+	for _, scripts := range []map[string]map[string]RawScript{base.Scripts, rz.Scripts} {
+		for host, types := range scripts {
+			if _, present := merged.Scripts[host]; !present {
+				merged.Scripts[host] = make(map[string]RawScript)
+			}
+			for t, s := range types {
+				merged.Scripts[host][t] = s
+			}
+		}
+	}
+	for _, ordering := range []map[string]RawZoneOrder{base.Ordering, rz.Ordering} {
+		for zone, o := range ordering {
+			merged.Ordering[zone] = o
+		}
+	}
+	for _, subnets := range []map[string]map[string][]RawSubnet{base.Subnets, rz.Subnets} {
+		for host, types := range subnets {
+			if _, present := merged.Subnets[host]; !present {
+				merged.Subnets[host] = make(map[string][]RawSubnet)
+			}
+			for t, s := range types {
+				merged.Subnets[host][t] = s
+			}
+		}
+	}
+
+	merged.Policies = append(merged.Policies, base.Policies...)
+	for _, p := range rz.Policies {
+		present := false
+		for _, existing := range merged.Policies {
+			present = present || sameName(existing, p)
+		}
+		if !present {
+			merged.Policies = append(merged.Policies, p)
+		}
+	}
+
+	merged.Views = append(merged.Views, base.Views...)
+	for _, rv := range rz.Views {
+		replaced := false
+		for i := range merged.Views {
+			if merged.Views[i].Name == rv.Name {
+				merged.Views[i] = rv
+				replaced = true
+			}
+		}
+		if !replaced {
+			merged.Views = append(merged.Views, rv)
+		}
+	}
+	return merged
+}
+
+// deleteZones returns base without the zones named in rz, along with their
+// ordering policies and policy zone entries, the contents of the zones in rz
+// are ignored
+func deleteZones(base, rz RawZones) (RawZones, error) {
+	ordering := make(map[string]RawZoneOrder)
+	for zone, o := range base.Ordering {
+		ordering[zone] = o
+	}
+	for zone := range rz.Zones {
+		key := zoneKey(base.Zones, zone)
+		if _, present := base.Zones[key]; !present {
+			return base, fmt.Errorf("No such zone %s", zone)
+		}
+		delete(base.Zones, key)
+		for oz := range ordering {
+			if sameName(oz, zone) {
+				delete(ordering, oz)
+			}
+		}
+	}
+	base.Ordering = ordering
+	kept := []string{}
+	for _, p := range base.Policies {
+		if _, present := rz.Zones[zoneKey(rz.Zones, p)]; !present {
+			kept = append(kept, p)
+		}
+	}
+	base.Policies = kept
+	return base, nil
+}
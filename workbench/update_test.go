@@ -0,0 +1,165 @@
+package workbench
+
+import (
+	"reflect"
+	"testing"
+)
+
+type rawHosts map[string]map[string][]string
+
+func zonesOf(zs map[string]rawHosts) map[string]map[string]map[string][]string {
+	out := make(map[string]map[string]map[string][]string)
+	for zone, hosts := range zs {
+		out[zone] = hosts
+	}
+	return out
+}
+
+// withMaps returns rz with empty maps in place of nil ones, as returned by
+// mergeZones
+func withMaps(rz RawZones) RawZones {
+	if rz.Zones == nil {
+		rz.Zones = make(map[string]map[string]map[string][]string)
+	}
+	if rz.Scripts == nil {
+		rz.Scripts = make(map[string]map[string]RawScript)
+	}
+	if rz.Ordering == nil {
+		rz.Ordering = make(map[string]RawZoneOrder)
+	}
+	if rz.Subnets == nil {
+		rz.Subnets = make(map[string]map[string][]RawSubnet)
+	}
+	return rz
+}
+
+func TestMergeZones(t *testing.T) {
+	exampleA := rawHosts{"example.com": {"a": {"192.0.2.1"}}}
+	exampleB := rawHosts{"example.com.": {"a": {"192.0.2.2"}}}
+	other := rawHosts{"other.com": {"a": {"192.0.2.3"}}}
+	seq := RawScript{Responses: []RawResponse{{Rcode: "nxdomain"}}}
+	rr := RawScript{Mode: "round-robin", Responses: []RawResponse{{Records: []string{"192.0.2.1"}}}}
+
+	for _, tc := range []struct {
+		desc string
+		base RawZones
+		rz   RawZones
+		want RawZones
+	}{
+		{
+			desc: "new zone is added",
+			base: RawZones{Zones: zonesOf(map[string]rawHosts{"example.com": exampleA})},
+			rz:   RawZones{Zones: zonesOf(map[string]rawHosts{"other.com": other})},
+			want: RawZones{Zones: zonesOf(map[string]rawHosts{"example.com": exampleA, "other.com": other})},
+		},
+		{
+			desc: "existing zone is replaced regardless of case or trailing dot",
+			base: RawZones{Zones: zonesOf(map[string]rawHosts{"Example.com": exampleA, "other.com": other})},
+			rz:   RawZones{Zones: zonesOf(map[string]rawHosts{"example.com.": exampleB})},
+			want: RawZones{Zones: zonesOf(map[string]rawHosts{"example.com.": exampleB, "other.com": other})},
+		},
+		{
+			desc: "scripts are merged per type",
+			base: RawZones{Scripts: map[string]map[string]RawScript{"www.example.com": {"a": seq, "aaaa": seq}}},
+			rz:   RawZones{Scripts: map[string]map[string]RawScript{"www.example.com": {"a": rr}}},
+			want: RawZones{Scripts: map[string]map[string]RawScript{"www.example.com": {"a": rr, "aaaa": seq}}},
+		},
+		{
+			desc: "ordering and subnets are replaced per key",
+			base: RawZones{
+				Ordering: map[string]RawZoneOrder{"example.com": {Policy: "fixed"}, "other.com": {Policy: "random"}},
+				Subnets:  map[string]map[string][]RawSubnet{"www.example.com": {"a": {{Subnet: "10.0.0.0/8"}}}},
+			},
+			rz: RawZones{
+				Ordering: map[string]RawZoneOrder{"example.com": {Policy: "cyclic"}},
+				Subnets:  map[string]map[string][]RawSubnet{"www.example.com": {"aaaa": {{Subnet: "2001:db8::/32"}}}},
+			},
+			want: RawZones{
+				Ordering: map[string]RawZoneOrder{"example.com": {Policy: "cyclic"}, "other.com": {Policy: "random"}},
+				Subnets: map[string]map[string][]RawSubnet{"www.example.com": {
+					"a":    {{Subnet: "10.0.0.0/8"}},
+					"aaaa": {{Subnet: "2001:db8::/32"}},
+				}},
+			},
+		},
+		{
+			desc: "policy zones are added once",
+			base: RawZones{Policies: []string{"rpz.local"}},
+			rz:   RawZones{Policies: []string{"RPZ.local.", "other.rpz"}},
+			want: RawZones{Policies: []string{"rpz.local", "other.rpz"}},
+		},
+		{
+			desc: "views are replaced by name",
+			base: RawZones{Views: []RawView{{Name: "internal", Nameserver: "a."}, {Name: "external"}}},
+			rz:   RawZones{Views: []RawView{{Name: "internal", Nameserver: "b."}, {Name: "lab"}}},
+			want: RawZones{Views: []RawView{{Name: "internal", Nameserver: "b."}, {Name: "external"}, {Name: "lab"}}},
+		},
+	} {
+		got := mergeZones(tc.base, tc.rz)
+		if want := withMaps(tc.want); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: mergeZones = %+v, expected %+v", tc.desc, got, want)
+		}
+	}
+}
+
+func TestDeleteZones(t *testing.T) {
+	example := rawHosts{"example.com": {"a": {"192.0.2.1"}}}
+	other := rawHosts{"other.com": {"a": {"192.0.2.3"}}}
+	rpz := rawHosts{"bad.example.com.rpz.local": {"cname": {"."}}}
+
+	for _, tc := range []struct {
+		desc string
+		base RawZones
+		rz   RawZones
+		want RawZones
+		err  bool
+	}{
+		{
+			desc: "zone is removed along with its ordering",
+			base: RawZones{
+				Zones:    zonesOf(map[string]rawHosts{"example.com": example, "other.com": other}),
+				Ordering: map[string]RawZoneOrder{"example.com": {Policy: "fixed"}, "other.com": {Policy: "random"}},
+			},
+			rz: RawZones{Zones: zonesOf(map[string]rawHosts{"EXAMPLE.com.": nil})},
+			want: RawZones{
+				Zones:    zonesOf(map[string]rawHosts{"other.com": other}),
+				Ordering: map[string]RawZoneOrder{"other.com": {Policy: "random"}},
+				Policies: []string{},
+			},
+		},
+		{
+			desc: "removed policy zones stop being used",
+			base: RawZones{
+				Zones:    zonesOf(map[string]rawHosts{"rpz.local": rpz, "other.com": other}),
+				Policies: []string{"rpz.local", "other.com"},
+			},
+			rz: RawZones{Zones: zonesOf(map[string]rawHosts{"rpz.local": nil})},
+			want: RawZones{
+				Zones:    zonesOf(map[string]rawHosts{"other.com": other}),
+				Ordering: map[string]RawZoneOrder{},
+				Policies: []string{"other.com"},
+			},
+		},
+		{
+			desc: "missing zone",
+			base: RawZones{Zones: zonesOf(map[string]rawHosts{"example.com": example})},
+			rz:   RawZones{Zones: zonesOf(map[string]rawHosts{"other.com": nil})},
+			err:  true,
+		},
+	} {
+		got, err := deleteZones(tc.base, tc.rz)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s: deleteZones succeeded, expected an error", tc.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: deleteZones failed: %s", tc.desc, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: deleteZones = %+v, expected %+v", tc.desc, got, tc.want)
+		}
+	}
+}
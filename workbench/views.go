@@ -0,0 +1,273 @@
+package workbench
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/github.com/miekg/dns"
+)
+
+// RawMatch holds the criteria used to select a view
+type RawMatch struct {
+	Clients   []string `yaml:"clients,omitempty" json:"clients"`
+	Listeners []string `yaml:"listeners,omitempty" json:"listeners"`
+	Keys      []string `yaml:"keys,omitempty" json:"keys"`
+}
+
+// RawView is a named set of zones served to queries matching Match. Setting
+// Nameserver makes the view act like a separate authoritative server called
+// that, using it in the generated SOA and NS records and refusing queries for
+// names outside its zones.
+type RawView struct {
+	Name       string                                    `yaml:"name" json:"name"`
+	Nameserver string                                    `yaml:"nameserver,omitempty" json:"nameserver"`
+	Match      RawMatch                                  `yaml:"match" json:"match"`
+	Zones      map[string]map[string]map[string][]string `yaml:"zones,omitempty" json:"zones"`
+	Scripts    map[string]map[string]RawScript           `yaml:"scripts,omitempty" json:"scripts"`
+	Ordering   map[string]RawZoneOrder                   `yaml:"ordering,omitempty" json:"ordering"`
+	Subnets    map[string]map[string][]RawSubnet         `yaml:"subnets,omitempty" json:"subnets"`
+	Policies   []string                                  `yaml:"policies,omitempty" json:"policies"`
+}
+
+func (rv RawView) raw() RawZones {
+	return RawZones{Zones: rv.Zones, Scripts: rv.Scripts, Ordering: rv.Ordering, Subnets: rv.Subnets, Policies: rv.Policies}
+}
+
+// view is a set of zones that is served to the queries matching all of its
+// criteria, a view with no criteria matches every query. The view built from
+// the top level zones has no name.
+type view struct {
+	name      string
+	clients   []*net.IPNet
+	listeners []string
+	keys      []string
+	// refuse queries for names outside z
+	refuse bool
+
+	z zones
+	a auth
+	s scripts
+	o orderings
+	e subnets
+	p policies
+}
+
+// parseNetwork parses a network in CIDR notation, a bare address is treated
+// as a network containing only that address
+func parseNetwork(c string) (*net.IPNet, error) {
+	if !strings.Contains(c, "/") {
+		if strings.Contains(c, ":") {
+			c += "/128"
+		} else {
+			c += "/32"
+		}
+	}
+	_, n, err := net.ParseCIDR(c)
+	return n, err
+}
+
+func constructView(name string, rm RawMatch, rz RawZones, serverName string, serials map[string]uint32) (*view, error) {
+	v := &view{name: name}
+	for _, c := range rm.Clients {
+		n, err := parseNetwork(c)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid client network for view %s: %v", name, err)
+		}
+		v.clients = append(v.clients, n)
+	}
+	for _, l := range rm.Listeners {
+		host, port, err := net.SplitHostPort(l)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid listener for view %s: %v", name, err)
+		}
+		v.listeners = append(v.listeners, net.JoinHostPort(host, port))
+	}
+	for _, k := range rm.Keys {
+		v.keys = append(v.keys, strings.ToLower(dns.Fqdn(k)))
+	}
+
+	var err error
+	v.z, v.a, err = constrcutZones(rz, serverName, serials)
+	if err != nil {
+		return nil, err
+	}
+	v.s, err = constructScripts(rz)
+	if err != nil {
+		return nil, err
+	}
+	v.o, err = constructOrderings(rz, v.z)
+	if err != nil {
+		return nil, err
+	}
+	v.e, err = constructSubnets(rz)
+	if err != nil {
+		return nil, err
+	}
+	v.p, err = constructPolicies(rz)
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// constructViews returns the views defined in rz in the order they should be
+// matched, the top level zones are used as the last, catch-all, view. serials
+// holds the SOA serials for each view keyed by view name.
+func constructViews(rz RawZones, serverName string, serials map[string]map[string]uint32) ([]*view, error) {
+	views := []*view{}
+	names := make(map[string]bool)
+	for _, rv := range rz.Views {
+		if rv.Name == "" {
+			return nil, fmt.Errorf("Views must have a name")
+		}
+		if names[rv.Name] {
+			return nil, fmt.Errorf("Duplicate view %s", rv.Name)
+		}
+		names[rv.Name] = true
+		name := serverName
+		if rv.Nameserver != "" {
+			name = dns.Fqdn(rv.Nameserver)
+		}
+		v, err := constructView(rv.Name, rv.Match, rv.raw(), name, serials[rv.Name])
+		if err != nil {
+			return nil, err
+		}
+		v.refuse = rv.Nameserver != ""
+		views = append(views, v)
+	}
+	v, err := constructView("", RawMatch{}, rz, serverName, serials[""])
+	if err != nil {
+		return nil, err
+	}
+	return append(views, v), nil
+}
+
+func (v *view) matches(client net.IP, listener, key string) bool {
+	if len(v.clients) > 0 {
+		found := false
+		for _, n := range v.clients {
+			if client != nil && n.Contains(client) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(v.listeners) > 0 {
+		found := false
+		for _, l := range v.listeners {
+			if l == listener {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(v.keys) > 0 {
+		found := false
+		for _, k := range v.keys {
+			if k == key {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (wb *Workbench) hasView(name string) bool {
+	for _, v := range wb.views {
+		if v.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// selectView returns the view called name, or if name is empty or there is
+// no such view the first view matching the query. key should be the name of
+// the verified TSIG key used to sign the query, if any.
+func (wb *Workbench) selectView(w dns.ResponseWriter, key, name string) *view {
+	if name != "" {
+		for _, v := range wb.views {
+			if v.name == name {
+				return v
+			}
+		}
+	}
+	client := net.ParseIP(clientAddr(w.RemoteAddr()))
+	listener := w.LocalAddr().String()
+	for _, v := range wb.views {
+		if v.matches(client, listener, key) {
+			return v
+		}
+	}
+	return nil
+}
+
+// zoneFor returns the most specific zone in the view containing name
+func (v *view) zoneFor(name string) string {
+	for off, end := 0, false; !end; off, end = dns.NextLabel(name, off) {
+		if _, present := v.z[name[off:]][dns.TypeSOA]; present {
+			return name[off:]
+		}
+	}
+	if _, present := v.z["."][dns.TypeSOA]; present {
+		return "."
+	}
+	return ""
+}
+
+// rawViews returns the zone definitions for each view in rz keyed by view
+// name, the top level zones use an empty name
+func rawViews(rz RawZones) map[string]RawZones {
+	rvs := map[string]RawZones{"": rz}
+	for _, rv := range rz.Views {
+		rvs[rv.Name] = rv.raw()
+	}
+	return rvs
+}
+
+// delegation returns the NS records for the closest delegation point below
+// the apex of zone that name falls under. Names at a delegation point that
+// have records of qType are answered as usual.
+func (v *view) delegation(name, zone string, qType uint16) []dns.RR {
+	if zone == "" {
+		return nil
+	}
+	for off, end := 0, false; !end; off, end = dns.NextLabel(name, off) {
+		cut := name[off:]
+		if cut == zone {
+			return nil
+		}
+		ns := v.z[cut][dns.TypeNS]
+		if len(ns) == 0 {
+			continue
+		}
+		if cut == name && len(v.z[cut][qType]) > 0 {
+			return nil
+		}
+		return ns
+	}
+	return nil
+}
+
+// glue returns the address records in the view for the targets of ns
+func (v *view) glue(ns []dns.RR) []dns.RR {
+	glue := []dns.RR{}
+	for _, rr := range ns {
+		if target, ok := rr.(*dns.NS); ok {
+			glue = append(glue, v.z[target.Ns][dns.TypeA]...)
+			glue = append(glue, v.z[target.Ns][dns.TypeAAAA]...)
+		}
+	}
+	return glue
+}
@@ -0,0 +1,136 @@
+package workbench_test
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/github.com/miekg/dns"
+	"github.com/rolandshoemaker/dns-workbench/workbench"
+	"github.com/rolandshoemaker/dns-workbench/workbench/workbenchtest"
+)
+
+const (
+	testKeyName   = "test-key."
+	testKeySecret = "c2VjcmV0LXNlY3JldC1zZWNyZXQ="
+)
+
+// freeAddr returns a local UDP and TCP address that was free when checked
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %s", err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func TestViewSelection(t *testing.T) {
+	matched := freeAddr(t)
+	zones := fmt.Sprintf(`
+views:
+  - name: signed
+    match:
+      keys: [%s]
+    zones:
+      example.com:
+        www.example.com:
+          a: [192.0.2.4]
+  - name: internal
+    match:
+      clients: [127.0.0.2, 127.0.1.0/24]
+    zones:
+      example.com:
+        www.example.com:
+          a: [192.0.2.2]
+  - name: listener
+    match:
+      listeners: ["%s"]
+    zones:
+      example.com:
+        www.example.com:
+          a: [192.0.2.3]
+  - name: pinned
+    match:
+      clients: [192.0.2.0/24]
+    zones:
+      example.com:
+        www.example.com:
+          a: [192.0.2.5]
+zones:
+  example.com:
+    www.example.com:
+      a: [192.0.2.1]
+`, testKeyName, matched)
+	s := workbenchtest.NewServerWithConfig(t, zones, workbench.Config{
+		Listeners: []workbench.Listener{
+			{Addr: "127.0.0.1:0"},
+			{Addr: matched},
+			// pinned to a view that wouldn't match any of these clients
+			{Addr: "127.0.0.1:0", View: "pinned"},
+		},
+		TSIGKeys: map[string]string{testKeyName: testKeySecret},
+	})
+	addrs := s.Addrs()
+	if len(addrs) != 3 {
+		t.Fatalf("Expected 3 listener addresses, got %q", addrs)
+	}
+
+	for _, tc := range []struct {
+		desc   string
+		addr   string
+		from   string
+		signed bool
+		want   string
+	}{
+		{"default", addrs[0], "127.0.0.1", false, "192.0.2.1"},
+		{"client address", addrs[0], "127.0.0.2", false, "192.0.2.2"},
+		{"client network", addrs[0], "127.0.1.9", false, "192.0.2.2"},
+		{"client outside network", addrs[0], "127.0.2.1", false, "192.0.2.1"},
+		{"listener", addrs[1], "127.0.0.1", false, "192.0.2.3"},
+		// views are matched in order
+		{"client before listener", addrs[1], "127.0.0.2", false, "192.0.2.2"},
+		{"listener view", addrs[2], "127.0.0.2", false, "192.0.2.5"},
+		{"TSIG key", addrs[0], "127.0.0.1", true, "192.0.2.4"},
+		{"TSIG key before client", addrs[0], "127.0.0.2", true, "192.0.2.4"},
+	} {
+		for _, network := range []string{"udp", "tcp"} {
+			m := new(dns.Msg)
+			m.SetQuestion("www.example.com.", dns.TypeA)
+			var tsig map[string]string
+			if tc.signed {
+				m.SetTsig(testKeyName, dns.HmacMD5, 300, time.Now().Unix())
+				tsig = map[string]string{testKeyName: testKeySecret}
+			}
+			resp := exchange(t, network, tc.addr, tc.from, m, tsig)
+			if got := values(resp.Answer); !reflect.DeepEqual(got, []string{tc.want}) {
+				t.Errorf("%s over %s: got %q, expected %s", tc.desc, network, got, tc.want)
+			}
+		}
+	}
+}
+
+func TestViewUnknownKey(t *testing.T) {
+	s := workbenchtest.NewServerWithConfig(t, exampleZones, workbench.Config{
+		TSIGKeys: map[string]string{testKeyName: testKeySecret},
+	})
+	for _, tc := range []struct {
+		desc   string
+		name   string
+		secret string
+	}{
+		{"unknown key", "other-key.", testKeySecret},
+		{"wrong secret", testKeyName, "d3Jvbmctc2VjcmV0"},
+	} {
+		m := new(dns.Msg)
+		m.SetQuestion("www.example.com.", dns.TypeA)
+		m.SetTsig(tc.name, dns.HmacMD5, 300, time.Now().Unix())
+		resp := exchange(t, "udp", s.Addr(), "", m, map[string]string{tc.name: tc.secret})
+		if resp.Rcode != dns.RcodeNotAuth {
+			t.Errorf("%s: got %s, expected NOTAUTH", tc.desc, dns.RcodeToString[resp.Rcode])
+		}
+	}
+}
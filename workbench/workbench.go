@@ -0,0 +1,829 @@
+// Package workbench implements a simple authoritative DNS server whose zones
+// can be swapped out or edited while it is running.
+package workbench
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/github.com/miekg/dns"
+)
+
+// Config holds the settings used to create a Workbench
+type Config struct {
+	// Hostname of the DNS server used in the generated SOA and NS records,
+	// defaults to localhost
+	Name string
+	// Address for the DNS server to listen on, defaults to 127.0.0.1:0 which
+	// picks a free port
+	Addr string
+	// Network for the DNS server to listen on, either udp (default) or tcp
+	Net string
+	// Listeners replace Addr and Net when the DNS server should listen on
+	// more than one address
+	Listeners []Listener
+	// Used by listeners with the tls network for DNS over TLS
+	TLSConfig *tls.Config
+	// Timeouts default to 2s, 2s and 8s respectively
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+	Compression  bool
+	// TSIG secrets accepted by the DNS server keyed by key name
+	TSIGKeys map[string]string
+	// Networks, or single addresses, allowed to query the DNS server, other
+	// clients are refused. Every client is allowed if empty.
+	AllowedClients []string
+	// Upstream resolvers keyed by the name suffix they are used for, queries
+	// for names without any records are forwarded to the resolvers for the
+	// longest matching suffix. The suffix . matches every name.
+	Forwarders map[string][]string
+	// Used to resolve names without any records that no forwarder covers,
+	// for queries with the RD bit set
+	Resolver *Resolver
+
+	// Logger for status messages, nothing is logged if nil
+	Logger *log.Logger
+	// Writer for the query log, queries aren't logged if nil
+	QueryLog io.Writer
+	// Either text (default) or json
+	QueryLogFormat string
+	// Prefix for lines in the text query log
+	QueryLogPrefix string
+	// Number of queries kept for the query history API, 0 disables it
+	QueryHistory int
+	// Called after every query has been answered
+	OnQuery func(*QueryEntry)
+	// Directory the zones, SOA serials and script positions are saved to, if
+	// it already holds saved state that is served instead of the zones passed
+	// to New. Nothing is saved if empty.
+	StateDir string
+}
+
+// Listener is an address the DNS server listens on
+type Listener struct {
+	Addr string
+	// Networks to listen on, either udp, tcp or tls (DNS over TLS), defaults
+	// to udp and tcp. If the port in Addr is 0 all of them, apart from tls,
+	// use the same free port.
+	Nets []string
+	// Name of the view used to answer every query on this listener, if empty
+	// the view is picked using the usual match criteria
+	View string
+}
+
+// server is a DNS server for a single address and network, either the
+// vendored server or, for DNS over TLS, a tlsServer
+type server struct {
+	*dns.Server
+	tls     *tlsServer
+	net     string
+	stopped chan struct{}
+	err     error
+}
+
+func (s *server) addr() string {
+	if s.tls != nil {
+		return s.tls.l.Addr().String()
+	}
+	if s.PacketConn != nil {
+		return s.PacketConn.LocalAddr().String()
+	}
+	return s.Listener.Addr().String()
+}
+
+// Workbench is an authoritative DNS server
+type Workbench struct {
+	mu      sync.RWMutex
+	views   []*view
+	rz      RawZones
+	serials map[string]map[string]uint32
+
+	// serializes changes to the zones so read-modify-write updates, like
+	// the record helpers and UpdateZones, can't lose concurrent changes
+	editMu sync.Mutex
+
+	stateDir string
+	saveMu   sync.Mutex
+
+	l  *log.Logger
+	ql *queryLog
+	qh *queryHistory
+	m  *metrics
+	// called after every query has been answered
+	onQuery func(*QueryEntry)
+
+	name        string
+	listeners   []Listener
+	tlsConfig   *tls.Config
+	rTimeout    time.Duration
+	wTimeout    time.Duration
+	iTimeout    time.Duration
+	compression bool
+	tsig        map[string]string
+	allowed     []*net.IPNet
+	forwarders  map[string][]string
+	resolver    *Resolver
+
+	servers  []*server
+	stopped  chan struct{}
+	stopOnce sync.Once
+	serveErr error
+	inflight sync.WaitGroup
+	// set once Shutdown has been called
+	stopping int32
+
+	shutdownOnce      sync.Once
+	shutdownRequested chan struct{}
+}
+
+// New creates a Workbench serving the zones in rz, the DNS server isn't
+// started until Start or ListenAndServe is called
+func New(rz RawZones, config Config) (*Workbench, error) {
+	wb := &Workbench{
+		l:           config.Logger,
+		m:           newMetrics(),
+		name:        dns.Fqdn(config.Name),
+		listeners:   config.Listeners,
+		tlsConfig:   config.TLSConfig,
+		rTimeout:    config.ReadTimeout,
+		wTimeout:    config.WriteTimeout,
+		iTimeout:    config.IdleTimeout,
+		compression: config.Compression,
+		onQuery:     config.OnQuery,
+		stateDir:    config.StateDir,
+
+		shutdownRequested: make(chan struct{}),
+	}
+	if config.Name == "" {
+		wb.name = "localhost."
+	}
+	if len(wb.listeners) == 0 {
+		l := Listener{Addr: config.Addr, Nets: []string{config.Net}}
+		if l.Addr == "" {
+			l.Addr = "127.0.0.1:0"
+		}
+		if config.Net == "" {
+			l.Nets = []string{"udp"}
+		}
+		wb.listeners = []Listener{l}
+	}
+	if wb.rTimeout == 0 {
+		wb.rTimeout = time.Second * 2
+	}
+	if wb.wTimeout == 0 {
+		wb.wTimeout = time.Second * 2
+	}
+	if wb.iTimeout == 0 {
+		wb.iTimeout = time.Second * 8
+	}
+	if wb.l == nil {
+		wb.l = log.New(ioutil.Discard, "", 0)
+	}
+	for k, secret := range config.TSIGKeys {
+		if wb.tsig == nil {
+			wb.tsig = make(map[string]string)
+		}
+		wb.tsig[dns.Fqdn(k)] = secret
+	}
+	for _, c := range config.AllowedClients {
+		n, err := parseNetwork(c)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid allowed client network: %v", err)
+		}
+		wb.allowed = append(wb.allowed, n)
+	}
+	forwarders, err := parseForwarders(config.Forwarders)
+	if err != nil {
+		return nil, err
+	}
+	wb.forwarders = forwarders
+	wb.resolver = config.Resolver
+	if config.QueryLog != nil {
+		format := config.QueryLogFormat
+		if format == "" {
+			format = "text"
+		}
+		var err error
+		wb.ql, err = newQueryLog(config.QueryLog, format, config.QueryLogPrefix)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if config.QueryHistory > 0 {
+		wb.qh = newQueryHistory(config.QueryHistory)
+	}
+
+	var st *state
+	if wb.stateDir != "" {
+		err := os.MkdirAll(wb.stateDir, 0755)
+		if err != nil {
+			return nil, err
+		}
+		st, err = loadState(wb.stateDir)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load state: %v", err)
+		}
+	}
+	if st != nil {
+		// unchanged zones keep their serials so start from the saved ones
+		rz = st.Zones
+		wb.rz = st.Zones
+		wb.serials = st.Serials
+	}
+
+	err = wb.setZones(rz)
+	if err != nil {
+		return nil, err
+	}
+	if st != nil {
+		wb.restoreScripts(st.Scripts)
+		wb.l.Printf("Restored state from %s\n", wb.stateDir)
+	}
+	return wb, nil
+}
+
+// SetZones replaces all of the zones being served, if rz cannot be parsed
+// an error is returned and the previous zones are kept. Zones that have
+// changed get a new SOA serial, scripts that haven't keep their positions.
+func (wb *Workbench) SetZones(rz RawZones) error {
+	wb.editMu.Lock()
+	defer wb.editMu.Unlock()
+	return wb.setZones(rz)
+}
+
+// setZones is SetZones for callers that already hold editMu
+func (wb *Workbench) setZones(rz RawZones) error {
+	serials := wb.assignSerials(rz)
+	nv, err := constructViews(rz, wb.name, serials)
+	if err != nil {
+		return err
+	}
+
+	wb.mu.Lock()
+	restoreScriptStates(nv, unchangedScripts(wb.scriptStates(), wb.rz, rz))
+	wb.views = nv
+	wb.rz = rz
+	wb.serials = serials
+	wb.mu.Unlock()
+
+	err = wb.SaveState()
+	if err != nil {
+		wb.l.Printf("Failed to save state: %s\n", err)
+	}
+	return nil
+}
+
+// ReloadFiles replaces all of the zones being served with the ones defined in
+// files and dirs as loaded by LoadFiles, if they cannot be parsed an error is
+// returned and the previous zones are kept
+func (wb *Workbench) ReloadFiles(files []string, dirs []string) error {
+	rStart := time.Now()
+	rz, err := LoadFiles(files, dirs)
+	if err == nil {
+		wb.editMu.Lock()
+		err = wb.setZones(rz)
+		wb.editMu.Unlock()
+	}
+	wb.finishReload(rStart, err)
+	return err
+}
+
+func (wb *Workbench) finishReload(rStart time.Time, err error) {
+	if wb.m != nil {
+		wb.m.observeReload(time.Since(rStart), err)
+	}
+	if err != nil {
+		return
+	}
+
+	wb.mu.RLock()
+	defer wb.mu.RUnlock()
+	wb.l.Printf("Reloaded zone definitions, now serving %d zones, took %s\n", wb.zoneCount(), time.Since(rStart))
+}
+
+// Zones returns a copy of the zone definitions currently being served
+func (wb *Workbench) Zones() RawZones {
+	wb.mu.RLock()
+	defer wb.mu.RUnlock()
+	rz := wb.rz
+	rz.Zones = make(map[string]map[string]map[string][]string, len(wb.rz.Zones))
+	for zone, hosts := range wb.rz.Zones {
+		rz.Zones[zone] = make(map[string]map[string][]string, len(hosts))
+		for host, types := range hosts {
+			rz.Zones[zone][host] = make(map[string][]string, len(types))
+			for t, values := range types {
+				rz.Zones[zone][host][t] = append([]string{}, values...)
+			}
+		}
+	}
+	return rz
+}
+
+func sameName(a, b string) bool {
+	return strings.ToLower(dns.Fqdn(a)) == strings.ToLower(dns.Fqdn(b))
+}
+
+// zoneKey returns the key used for zone in zs, or zone itself if it isn't
+// defined
+func zoneKey(zs map[string]map[string]map[string][]string, zone string) string {
+	for z := range zs {
+		if sameName(z, zone) {
+			return z
+		}
+	}
+	return zone
+}
+
+// hostKey returns the key used for host in hosts, or host itself if it isn't
+// defined
+func hostKey(hosts map[string]map[string][]string, host string) string {
+	for h := range hosts {
+		if sameName(h, host) {
+			return h
+		}
+	}
+	return host
+}
+
+// AddRecords adds records in the presentation format to host in zone,
+// creating the zone and host if they don't already exist
+func (wb *Workbench) AddRecords(zone, host, rrType string, values ...string) error {
+	wb.editMu.Lock()
+	defer wb.editMu.Unlock()
+	rz := wb.Zones()
+	if rz.Zones == nil {
+		rz.Zones = make(map[string]map[string]map[string][]string)
+	}
+
+	zone = zoneKey(rz.Zones, zone)
+	if _, present := rz.Zones[zone]; !present {
+		rz.Zones[zone] = make(map[string]map[string][]string)
+	}
+	host = hostKey(rz.Zones[zone], host)
+	if _, present := rz.Zones[zone][host]; !present {
+		rz.Zones[zone][host] = make(map[string][]string)
+	}
+	rrType = strings.ToLower(rrType)
+	rz.Zones[zone][host][rrType] = append(rz.Zones[zone][host][rrType], values...)
+	return wb.setZones(rz)
+}
+
+// RemoveRecords removes all records of rrType from host in zone
+func (wb *Workbench) RemoveRecords(zone, host, rrType string) error {
+	wb.editMu.Lock()
+	defer wb.editMu.Unlock()
+	rz := wb.Zones()
+
+	zone = zoneKey(rz.Zones, zone)
+	host = hostKey(rz.Zones[zone], host)
+	removed := false
+	for t := range rz.Zones[zone][host] {
+		if strings.ToLower(t) == strings.ToLower(rrType) {
+			delete(rz.Zones[zone][host], t)
+			removed = true
+		}
+	}
+	if !removed {
+		return fmt.Errorf("No %s records for %s in zone %s", rrType, host, zone)
+	}
+	if len(rz.Zones[zone][host]) == 0 {
+		delete(rz.Zones[zone], host)
+	}
+	return wb.setZones(rz)
+}
+
+// RemoveZone stops serving zone
+func (wb *Workbench) RemoveZone(zone string) error {
+	wb.editMu.Lock()
+	defer wb.editMu.Unlock()
+	rz := wb.Zones()
+
+	zone = zoneKey(rz.Zones, zone)
+	if _, present := rz.Zones[zone]; !present {
+		return fmt.Errorf("No such zone %s", zone)
+	}
+	delete(rz.Zones, zone)
+	return wb.setZones(rz)
+}
+
+func (wb *Workbench) zoneCount() int {
+	count := 0
+	for _, v := range wb.views {
+		count += len(v.z)
+	}
+	return count
+}
+
+// dnsHandler answers r using the view called view, or the first view that
+// matches the query if view is empty
+func (wb *Workbench) dnsHandler(w dns.ResponseWriter, r *dns.Msg, view string) {
+	wb.inflight.Add(1)
+	defer wb.inflight.Done()
+	// once shutting down queries aren't answered or logged, this covers the
+	// empty query the server wakes up its read loop with and queries on TCP
+	// connections that were already open, which are closed. Hijacking stops
+	// the server from reading from the connection after it's closed.
+	if atomic.LoadInt32(&wb.stopping) == 1 {
+		w.Hijack()
+		w.Close()
+		return
+	}
+	started := time.Now()
+	rw := &recordingWriter{ResponseWriter: w}
+	zone, fq := wb.answer(rw, r, view)
+	// upstreams are queried without holding the zone lock
+	var upstream string
+	if fq != nil {
+		upstream = wb.forward(r, fq)
+	}
+	if wb.ql == nil && wb.qh == nil && wb.m == nil && wb.onQuery == nil {
+		return
+	}
+	qe := newQueryEntry(w, r, rw.msg, zone, started)
+	qe.Upstream = upstream
+	qe.Policy = rw.policy
+	if wb.ql != nil {
+		wb.ql.log(qe)
+	}
+	if wb.qh != nil {
+		wb.qh.add(qe)
+	}
+	if wb.m != nil {
+		wb.m.observeQuery(qe)
+	}
+	if wb.onQuery != nil {
+		wb.onQuery(qe)
+	}
+}
+
+// answer writes the response to r and returns the zone used to answer it,
+// if r should be forwarded nothing is written and the query is returned
+func (wb *Workbench) answer(w dns.ResponseWriter, r *dns.Msg, view string) (zone string, fq *forwardedQuery) {
+	wb.mu.RLock()
+	defer wb.mu.RUnlock()
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Compress = wb.compression
+
+	if !wb.allowedClient(w.RemoteAddr()) {
+		m.Rcode = dns.RcodeRefused
+		w.WriteMsg(m)
+		return
+	}
+	if len(r.Question) > 1 || r.Rcode != dns.OpcodeQuery {
+		m.Rcode = dns.RcodeNotImplemented
+	} else if len(r.Question) == 0 {
+		m.Rcode = dns.RcodeFormatError
+	}
+	if m.Rcode == dns.RcodeFormatError || m.Rcode == dns.RcodeNotImplemented {
+		w.WriteMsg(m)
+		return
+	}
+
+	q := &r.Question[0]
+
+	if r.IsEdns0() != nil {
+		m.SetEdns0(dns.DefaultMsgSize, false)
+	}
+	ecs, err := requestSubnet(r)
+	if err != nil {
+		m.Rcode = dns.RcodeFormatError
+		w.WriteMsg(m)
+		return
+	}
+
+	var key string
+	if t := r.IsTsig(); t != nil {
+		if _, known := wb.tsig[t.Hdr.Name]; !known || w.TsigStatus() != nil {
+			m.Rcode = dns.RcodeNotAuth
+			w.WriteMsg(m)
+			return
+		}
+		key = strings.ToLower(t.Hdr.Name)
+		m.SetTsig(t.Hdr.Name, t.Algorithm, 300, time.Now().Unix())
+	}
+
+	v := wb.selectView(w, key, view)
+	zone = v.zoneFor(q.Name)
+	if len(v.p) > 0 {
+		rule := v.p.matchQuery(q.Name)
+		if rule != nil && rule.action == policyTCPOnly && overTCP(w) {
+			rule = nil
+		}
+		// passthru exempts the name from every other rule
+		if rule == nil || rule.action != policyPassthru {
+			pw := &policyWriter{ResponseWriter: w, p: v.p, r: r, rule: rule}
+			if rule != nil {
+				pw.WriteMsg(m)
+				return
+			}
+			w = pw
+		}
+	}
+	subnetAddr := net.ParseIP(clientAddr(w.RemoteAddr()))
+	var echo *dns.EDNS0_SUBNET
+	if ecs != nil {
+		subnetAddr = ecs.Address
+		echo = echoSubnet(m, ecs)
+	}
+
+	if s := v.s.lookup(q.Name, q.Qtype); s != nil {
+		resp := s.next(clientAddr(w.RemoteAddr()))
+		if auth, present := v.a[q.Name]; present {
+			m.Authoritative = true
+			m.Ns = append(m.Ns, *auth)
+		}
+		m.Rcode = resp.rcode
+		m.Answer = append(m.Answer, resp.records...)
+		w.WriteMsg(m)
+		return
+	}
+
+	if records, scope, present := v.e.lookup(q.Name, q.Qtype, subnetAddr); present {
+		if auth, present := v.a[q.Name]; present {
+			m.Authoritative = true
+			m.Ns = append(m.Ns, *auth)
+		}
+		if echo != nil {
+			echo.SourceScope = scope
+		}
+		m.Answer = append(m.Answer, records...)
+		w.WriteMsg(m)
+		return
+	}
+
+	if ns := v.delegation(q.Name, zone, q.Qtype); ns != nil {
+		m.Ns = append(m.Ns, ns...)
+		// keep any OPT and TSIG records at the end
+		m.Extra = append(v.glue(ns), m.Extra...)
+		w.WriteMsg(m)
+		return
+	}
+	if zone == "" && v.refuse {
+		m.Rcode = dns.RcodeRefused
+		w.WriteMsg(m)
+		return
+	}
+
+	allRecords, present := v.z[q.Name]
+	if !present {
+		upstreams := wb.upstreams(q.Name)
+		if upstreams != nil || (wb.resolver != nil && r.RecursionDesired) {
+			fq = &forwardedQuery{upstreams: upstreams, reply: m, w: w}
+			return
+		}
+		m.Rcode = dns.RcodeNameError
+		w.WriteMsg(m)
+		return
+	}
+
+	if auth, present := v.a[q.Name]; present {
+		m.Authoritative = true
+		m.Ns = append(m.Ns, *auth)
+	}
+
+	qRecords, present := allRecords[q.Qtype]
+	if cname, isAlias := allRecords[dns.TypeCNAME]; !present && isAlias {
+		// resolvers restart the query with the target
+		m.Answer = append(m.Answer, cname...)
+		w.WriteMsg(m)
+		return
+	}
+	if !present {
+		m.Rcode = dns.RcodeNXRrset
+		w.WriteMsg(m)
+		return
+	}
+
+	m.Answer = append(m.Answer, v.o.apply(q.Name, q.Qtype, qRecords)...)
+	w.WriteMsg(m)
+	return
+}
+
+func (wb *Workbench) allowedClient(addr net.Addr) bool {
+	if len(wb.allowed) == 0 {
+		return true
+	}
+	client := net.ParseIP(clientAddr(addr))
+	for _, n := range wb.allowed {
+		if client != nil && n.Contains(client) {
+			return true
+		}
+	}
+	return false
+}
+
+// Start starts the DNS server in the background and returns once it is
+// listening on every listener
+func (wb *Workbench) Start() error {
+	wb.stopped = make(chan struct{})
+	for _, l := range wb.listeners {
+		err := wb.startListener(l)
+		if err != nil {
+			wb.Shutdown(wb.rTimeout + wb.wTimeout)
+			return err
+		}
+	}
+
+	wb.mu.RLock()
+	defer wb.mu.RUnlock()
+	for _, l := range wb.listeners {
+		if l.View != "" && !wb.hasView(l.View) {
+			wb.l.Printf("No view called %s for listener %s, using the usual view selection\n", l.View, l.Addr)
+		}
+	}
+	wb.l.Printf("DNS listening on %s, serving %d zones\n", strings.Join(wb.Addrs(), ", "), wb.zoneCount())
+	return nil
+}
+
+func (wb *Workbench) startListener(l Listener) error {
+	nets := l.Nets
+	if len(nets) == 0 {
+		nets = []string{"udp", "tcp"}
+	}
+	addr := l.Addr
+	handler := func(w dns.ResponseWriter, r *dns.Msg) {
+		wb.dnsHandler(w, r, l.View)
+	}
+	for _, network := range nets {
+		s := &server{
+			Server: &dns.Server{
+				Net:          network,
+				Handler:      dns.HandlerFunc(handler),
+				TsigSecret:   wb.tsig,
+				ReadTimeout:  wb.rTimeout,
+				WriteTimeout: wb.wTimeout,
+				IdleTimeout:  func() time.Duration { return wb.iTimeout },
+			},
+			net:     network,
+			stopped: make(chan struct{}),
+		}
+		switch network {
+		case "tcp", "tcp4", "tcp6":
+			ln, err := net.Listen(network, addr)
+			if err != nil {
+				return err
+			}
+			s.Listener = ln
+		case "udp", "udp4", "udp6":
+			pc, err := net.ListenPacket(network, addr)
+			if err != nil {
+				return err
+			}
+			s.PacketConn = pc
+		case "tls":
+			if wb.tlsConfig == nil {
+				return fmt.Errorf("A TLS config is required for tls listeners")
+			}
+			ln, err := net.Listen("tcp", l.Addr)
+			if err != nil {
+				return err
+			}
+			s.tls = newTLSServer(ln, wb.tlsConfig, dns.HandlerFunc(handler), wb.tsig, wb.rTimeout, wb.iTimeout)
+		default:
+			return fmt.Errorf("Unsupported network %s", network)
+		}
+		// use the same port for every network if a free one was picked, TLS
+		// can't share one with TCP
+		if s.tls == nil {
+			addr = s.addr()
+		}
+
+		started := make(chan struct{})
+		s.NotifyStartedFunc = func() { close(started) }
+		wb.servers = append(wb.servers, s)
+		go func() {
+			if s.tls != nil {
+				close(started)
+				s.err = s.tls.serve()
+			} else {
+				s.err = s.ActivateAndServe()
+			}
+			close(s.stopped)
+			wb.stopOnce.Do(func() {
+				wb.serveErr = s.err
+				close(wb.stopped)
+			})
+		}()
+		select {
+		case <-started:
+		case <-s.stopped:
+			return s.err
+		}
+	}
+	return nil
+}
+
+// ListenAndServe starts the DNS server and blocks until it is stopped or one
+// of the listeners fails
+func (wb *Workbench) ListenAndServe() error {
+	err := wb.Start()
+	if err != nil {
+		return err
+	}
+	<-wb.stopped
+	return wb.serveErr
+}
+
+// Stop shuts down the DNS server once all in-flight queries are answered,
+// giving up after the read and write timeouts have passed
+func (wb *Workbench) Stop() error {
+	return wb.Shutdown(wb.rTimeout + wb.wTimeout)
+}
+
+// Shutdown stops the DNS server from accepting new queries, waits for all
+// in-flight queries to be answered and saves the state, returning an error if
+// that takes longer than timeout
+func (wb *Workbench) Shutdown(timeout time.Duration) error {
+	if len(wb.servers) == 0 {
+		return fmt.Errorf("Workbench not started")
+	}
+	atomic.StoreInt32(&wb.stopping, 1)
+	expired := time.After(timeout)
+	for _, s := range wb.servers {
+		addr := s.addr()
+		if s.tls != nil {
+			s.tls.shutdown()
+		} else {
+			go s.Shutdown()
+		}
+
+		// The server only checks for a shutdown request once a read returns
+		// and the query Shutdown sends to wake it up can arrive before the
+		// request is made, so keep nudging it until it notices
+		for stopped := false; !stopped; {
+			select {
+			case <-s.stopped:
+				stopped = true
+			case <-expired:
+				return fmt.Errorf("Timed out waiting for DNS server to stop")
+			case <-time.After(time.Millisecond * 50):
+				if s.tls != nil {
+					continue
+				}
+				if conn, err := net.Dial(s.net, addr); err == nil {
+					conn.Write([]byte{0})
+					conn.Close()
+				}
+			}
+		}
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		wb.inflight.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-expired:
+		return fmt.Errorf("Timed out waiting for in-flight queries")
+	}
+	return wb.SaveState()
+}
+
+// RequestShutdown asks whoever is running the workbench to shut it down, it
+// is called by the /api/shutdown endpoint
+func (wb *Workbench) RequestShutdown() {
+	wb.shutdownOnce.Do(func() {
+		close(wb.shutdownRequested)
+	})
+}
+
+// ShutdownRequested returns a channel that is closed once RequestShutdown
+// has been called
+func (wb *Workbench) ShutdownRequested() <-chan struct{} {
+	return wb.shutdownRequested
+}
+
+// Addr returns the address of the first listener
+func (wb *Workbench) Addr() string {
+	if len(wb.servers) > 0 {
+		return wb.servers[0].addr()
+	}
+	return wb.listeners[0].Addr
+}
+
+// Addrs returns the addresses the DNS server is listening on, without
+// duplicates when an address is used for more than one network
+func (wb *Workbench) Addrs() []string {
+	addrs := []string{}
+	seen := make(map[string]bool)
+	for _, s := range wb.servers {
+		if addr := s.addr(); !seen[addr] {
+			seen[addr] = true
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
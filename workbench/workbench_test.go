@@ -0,0 +1,184 @@
+package workbench_test
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/github.com/miekg/dns"
+	"github.com/rolandshoemaker/dns-workbench/workbench"
+)
+
+const exampleZones = `
+zones:
+  example.com:
+    www.example.com:
+      a: [192.0.2.1]
+`
+
+func newWorkbench(t *testing.T, zonesYAML string, config workbench.Config) *workbench.Workbench {
+	t.Helper()
+	rz, err := workbench.ParseYAML([]byte(zonesYAML))
+	if err != nil {
+		t.Fatalf("Failed to parse zones: %s", err)
+	}
+	wb, err := workbench.New(rz, config)
+	if err != nil {
+		t.Fatalf("Failed to create workbench: %s", err)
+	}
+	err = wb.Start()
+	if err != nil {
+		t.Fatalf("Failed to start workbench: %s", err)
+	}
+	return wb
+}
+
+// zonesError returns the error creating a workbench serving zonesYAML
+func zonesError(zonesYAML string) error {
+	rz, err := workbench.ParseYAML([]byte(zonesYAML))
+	if err != nil {
+		return err
+	}
+	_, err = workbench.New(rz, workbench.Config{})
+	return err
+}
+
+// exchange sends m to addr over network, from the local address from if it
+// isn't empty, and returns the response
+func exchange(t *testing.T, network, addr, from string, m *dns.Msg, tsig map[string]string) *dns.Msg {
+	t.Helper()
+	d := &net.Dialer{Timeout: time.Second}
+	if from != "" {
+		ip := net.ParseIP(from)
+		if network == "tcp" {
+			d.LocalAddr = &net.TCPAddr{IP: ip}
+		} else {
+			d.LocalAddr = &net.UDPAddr{IP: ip}
+		}
+	}
+	c, err := d.Dial(network, addr)
+	if err != nil {
+		t.Fatalf("Failed to connect to %s: %s", addr, err)
+	}
+	co := &dns.Conn{Conn: c, TsigSecret: tsig}
+	defer co.Close()
+	co.SetDeadline(time.Now().Add(2 * time.Second))
+	err = co.WriteMsg(m)
+	if err != nil {
+		t.Fatalf("Failed to send query: %s", err)
+	}
+	resp, err := co.ReadMsg()
+	if err != nil {
+		t.Fatalf("Failed to read response: %s", err)
+	}
+	return resp
+}
+
+// ask queries addr over UDP for name and qType
+func ask(t *testing.T, addr, name string, qType uint16) *dns.Msg {
+	t.Helper()
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), qType)
+	return exchange(t, "udp", addr, "", m, nil)
+}
+
+// values returns the presentation values of the records in rrs
+func values(rrs []dns.RR) []string {
+	out := []string{}
+	for _, rr := range rrs {
+		out = append(out, strings.TrimSpace(strings.TrimPrefix(rr.String(), rr.Header().String())))
+	}
+	return out
+}
+
+func TestStopClosesConnections(t *testing.T) {
+	wb := newWorkbench(t, exampleZones, workbench.Config{Net: "tcp"})
+	conn, err := dns.Dial("tcp", wb.Addr())
+	if err != nil {
+		t.Fatalf("Failed to connect: %s", err)
+	}
+	defer conn.Close()
+
+	m := new(dns.Msg)
+	m.SetQuestion("www.example.com.", dns.TypeA)
+	err = conn.WriteMsg(m)
+	if err != nil {
+		t.Fatalf("Failed to send query: %s", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	resp, err := conn.ReadMsg()
+	if err != nil {
+		t.Fatalf("Failed to read response: %s", err)
+	}
+	if len(resp.Answer) != 1 {
+		t.Fatalf("Expected 1 answer before stopping, got %d", len(resp.Answer))
+	}
+
+	err = wb.Stop()
+	if err != nil {
+		t.Fatalf("Failed to stop workbench: %s", err)
+	}
+
+	// writing may still succeed but nothing should be answered
+	m.Id = dns.Id()
+	conn.WriteMsg(m)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	resp, err = conn.ReadMsg()
+	if err == nil {
+		t.Fatalf("Query on an open connection was answered after Stop: %s", resp)
+	}
+}
+
+func TestStopRefusesNewQueries(t *testing.T) {
+	for _, network := range []string{"udp", "tcp"} {
+		wb := newWorkbench(t, exampleZones, workbench.Config{Net: network})
+		addr := wb.Addr()
+		err := wb.Stop()
+		if err != nil {
+			t.Fatalf("%s: failed to stop workbench: %s", network, err)
+		}
+
+		c := &dns.Client{Net: network, DialTimeout: time.Second, ReadTimeout: time.Second}
+		m := new(dns.Msg)
+		m.SetQuestion("www.example.com.", dns.TypeA)
+		if resp, _, err := c.Exchange(m, addr); err == nil {
+			t.Errorf("%s: query was answered after Stop: %s", network, resp)
+		}
+	}
+}
+
+func TestCNAMEAnswers(t *testing.T) {
+	wb := newWorkbench(t, `
+zones:
+  example.com:
+    www.example.com:
+      a: [192.0.2.1]
+    alias.example.com:
+      cname: [www.example.com.]
+`, workbench.Config{})
+	defer wb.Stop()
+
+	for _, tc := range []struct {
+		desc  string
+		name  string
+		qType uint16
+		rcode int
+		want  []string
+	}{
+		// the target isn't followed, resolvers restart the query with it
+		{"alias A", "alias.example.com", dns.TypeA, dns.RcodeSuccess, []string{"www.example.com."}},
+		{"alias AAAA", "alias.example.com", dns.TypeAAAA, dns.RcodeSuccess, []string{"www.example.com."}},
+		{"alias CNAME", "alias.example.com", dns.TypeCNAME, dns.RcodeSuccess, []string{"www.example.com."}},
+		{"missing type", "www.example.com", dns.TypeAAAA, dns.RcodeNXRrset, []string{}},
+	} {
+		resp := ask(t, wb.Addr(), tc.name, tc.qType)
+		if resp.Rcode != tc.rcode {
+			t.Errorf("%s: got %s, expected %s", tc.desc, dns.RcodeToString[resp.Rcode], dns.RcodeToString[tc.rcode])
+		}
+		if got := values(resp.Answer); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %q, expected %q", tc.desc, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// Package workbenchtest provides a workbench DNS server for use in Go tests,
+// in the spirit of net/http/httptest.
+package workbenchtest
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/github.com/miekg/dns"
+	"github.com/rolandshoemaker/dns-workbench/workbench"
+)
+
+// Server is a workbench listening on a random local port that is stopped
+// when the test finishes
+type Server struct {
+	*workbench.Workbench
+
+	t            testing.TB
+	mu           sync.Mutex
+	queries      []*workbench.QueryEntry
+	expectations []*Expectation
+}
+
+// NewServer starts a workbench serving the zones in zonesYAML, which uses the
+// zone file format, the test fails immediately if the server can't be started.
+// Expectations are checked when the test finishes.
+func NewServer(t testing.TB, zonesYAML string) *Server {
+	t.Helper()
+	return NewServerWithConfig(t, zonesYAML, workbench.Config{})
+}
+
+// NewServerWithConfig is NewServer using config, which can set up listeners,
+// TSIG keys and the like. OnQuery is still called if set.
+func NewServerWithConfig(t testing.TB, zonesYAML string, config workbench.Config) *Server {
+	t.Helper()
+	rz, err := workbench.ParseYAML([]byte(zonesYAML))
+	if err != nil {
+		t.Fatalf("Failed to parse zones: %s", err)
+	}
+
+	s := &Server{t: t}
+	onQuery := config.OnQuery
+	config.OnQuery = func(qe *workbench.QueryEntry) {
+		s.record(qe)
+		if onQuery != nil {
+			onQuery(qe)
+		}
+	}
+	wb, err := workbench.New(rz, config)
+	if err != nil {
+		t.Fatalf("Failed to create workbench: %s", err)
+	}
+	err = wb.Start()
+	if err != nil {
+		t.Fatalf("Failed to start workbench: %s", err)
+	}
+	s.Workbench = wb
+
+	t.Cleanup(func() {
+		err := s.Stop()
+		if err != nil {
+			t.Errorf("Failed to stop workbench: %s", err)
+		}
+		s.AssertExpectations()
+	})
+	return s
+}
+
+func (s *Server) record(qe *workbench.QueryEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queries = append(s.queries, qe)
+}
+
+// Queries returns every query received so far, oldest first
+func (s *Server) Queries() []*workbench.QueryEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*workbench.QueryEntry{}, s.queries...)
+}
+
+// Reset forgets every query received so far, expectations are kept
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queries = nil
+}
+
+// Expectation is a query the server should receive before the test finishes
+type Expectation struct {
+	name  string
+	qType string
+	times int
+}
+
+// ExpectQuery adds an expectation that name is queried for qType at least
+// once, the number of queries can be pinned using Times
+func (s *Server) ExpectQuery(name, qType string) *Expectation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := &Expectation{
+		name:  strings.ToLower(dns.Fqdn(name)),
+		qType: strings.ToUpper(qType),
+		times: -1,
+	}
+	s.expectations = append(s.expectations, e)
+	return e
+}
+
+// Times sets the exact number of queries expected, use 0 to assert the name
+// is never queried
+func (e *Expectation) Times(n int) *Expectation {
+	e.times = n
+	return e
+}
+
+func (e *Expectation) String() string {
+	if e.times < 0 {
+		return fmt.Sprintf("[%s] %s at least once", e.qType, e.name)
+	}
+	return fmt.Sprintf("[%s] %s %d times", e.qType, e.name, e.times)
+}
+
+func (e *Expectation) matches(qe *workbench.QueryEntry) bool {
+	return strings.ToLower(qe.Name) == e.name && qe.Type == e.qType
+}
+
+// AssertExpectations fails the test if any expectation hasn't been met, it
+// is called automatically when the test finishes
+func (s *Server) AssertExpectations() {
+	s.t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.expectations {
+		count := 0
+		for _, qe := range s.queries {
+			if e.matches(qe) {
+				count++
+			}
+		}
+		if (e.times < 0 && count == 0) || (e.times >= 0 && count != e.times) {
+			s.t.Errorf("Expected query for %s, got %d", e, count)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package workbenchtest
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/github.com/miekg/dns"
+	"github.com/rolandshoemaker/dns-workbench/workbench"
+)
+
+const testZones = `
+zones:
+  example.com:
+    www.example.com:
+      a: [192.0.2.1]
+`
+
+// recordingTB collects errors and cleanup functions instead of acting on
+// them so failing expectations can be checked
+type recordingTB struct {
+	testing.TB
+	errors   []string
+	cleanups []func()
+}
+
+func (r *recordingTB) Helper() {}
+
+func (r *recordingTB) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func (r *recordingTB) Fatalf(format string, args ...interface{}) {
+	panic(fmt.Sprintf(format, args...))
+}
+
+func (r *recordingTB) Cleanup(f func()) {
+	r.cleanups = append(r.cleanups, f)
+}
+
+// finish runs the cleanup functions like the testing package does when a
+// test finishes
+func (r *recordingTB) finish() {
+	for i := len(r.cleanups) - 1; i >= 0; i-- {
+		r.cleanups[i]()
+	}
+}
+
+func query(t *testing.T, s *Server, name string, qType uint16, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		m := new(dns.Msg)
+		m.SetQuestion(name, qType)
+		_, err := dns.Exchange(m, s.Addr())
+		if err != nil {
+			t.Fatalf("Query for %s failed: %s", name, err)
+		}
+	}
+}
+
+func TestExpectQueryTimes(t *testing.T) {
+	s := NewServer(t, testZones)
+	s.ExpectQuery("WWW.example.com", "a").Times(2)
+	s.ExpectQuery("other.example.com", "A").Times(0)
+	query(t, s, "www.example.com.", dns.TypeA, 2)
+	query(t, s, "www.example.com.", dns.TypeAAAA, 1)
+}
+
+func TestExpectQueryFailures(t *testing.T) {
+	for _, tc := range []struct {
+		desc    string
+		expect  func(s *Server)
+		queries int
+		want    string
+	}{
+		{
+			desc:    "too few queries",
+			expect:  func(s *Server) { s.ExpectQuery("www.example.com", "A").Times(3) },
+			queries: 2,
+			want:    "Expected query for [A] www.example.com. 3 times, got 2",
+		},
+		{
+			desc:    "too many queries",
+			expect:  func(s *Server) { s.ExpectQuery("www.example.com", "A").Times(1) },
+			queries: 2,
+			want:    "Expected query for [A] www.example.com. 1 times, got 2",
+		},
+		{
+			desc:    "unexpected query",
+			expect:  func(s *Server) { s.ExpectQuery("www.example.com", "A").Times(0) },
+			queries: 1,
+			want:    "Expected query for [A] www.example.com. 0 times, got 1",
+		},
+		{
+			desc:    "never queried",
+			expect:  func(s *Server) { s.ExpectQuery("www.example.com", "A") },
+			queries: 0,
+			want:    "Expected query for [A] www.example.com. at least once, got 0",
+		},
+	} {
+		rt := &recordingTB{}
+		s := NewServer(rt, testZones)
+		tc.expect(s)
+		query(t, s, "www.example.com.", dns.TypeA, tc.queries)
+		rt.finish()
+		if len(rt.errors) != 1 || rt.errors[0] != tc.want {
+			t.Errorf("%s: got errors %q, expected %q", tc.desc, rt.errors, tc.want)
+		}
+	}
+}
+
+func TestNewServerWithConfig(t *testing.T) {
+	called := 0
+	s := NewServerWithConfig(t, testZones, workbench.Config{
+		Net:     "tcp",
+		OnQuery: func(*workbench.QueryEntry) { called++ },
+	})
+	s.ExpectQuery("www.example.com", "A").Times(1)
+
+	c := &dns.Client{Net: "tcp"}
+	m := new(dns.Msg)
+	m.SetQuestion("www.example.com.", dns.TypeA)
+	_, _, err := c.Exchange(m, s.Addr())
+	if err != nil {
+		t.Fatalf("Query over TCP failed: %s", err)
+	}
+	err = s.Stop()
+	if err != nil {
+		t.Fatalf("Failed to stop workbench: %s", err)
+	}
+	if called != 1 {
+		t.Errorf("OnQuery was called %d times, expected 1", called)
+	}
+}
@@ -0,0 +1,132 @@
+package workbench
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/github.com/miekg/dns"
+	"github.com/rolandshoemaker/dns-workbench/Godeps/_workspace/src/gopkg.in/yaml.v2"
+)
+
+// RawZones is a zone definition as found in a workbench zone file
+type RawZones struct {
+	// so horribly gross but w/e for now
+	Zones    map[string]map[string]map[string][]string `yaml:"zones,omitempty"`
+	Scripts  map[string]map[string]RawScript           `yaml:"scripts,omitempty" json:"scripts"`
+	Ordering map[string]RawZoneOrder                   `yaml:"ordering,omitempty" json:"ordering"`
+	Subnets  map[string]map[string][]RawSubnet         `yaml:"subnets,omitempty" json:"subnets"`
+	Views    []RawView                                 `yaml:"views,omitempty" json:"views"`
+	Policies []string                                  `yaml:"policies,omitempty" json:"policies"`
+}
+
+type zones map[string]map[uint16][]dns.RR
+type auth map[string]*dns.RR
+
+func newRecord(host, typeStr, presentation string) (dns.RR, error) {
+	rr, err := dns.NewRR(fmt.Sprintf("%s %s %s", host, strings.ToUpper(typeStr), presentation))
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't parse record: %v", err)
+	}
+	return rr, nil
+}
+
+// newSerial returns a SOA serial based on the current time, or prev+1 if that
+// wouldn't be higher than prev
+func newSerial(prev uint32) uint32 {
+	serial, _ := strconv.ParseUint(time.Now().Format("0601021504"), 10, 32)
+	if uint32(serial) <= prev {
+		return prev + 1
+	}
+	return uint32(serial)
+}
+
+// constrcutZones builds the zones in rz, serials holds the SOA serial for
+// each zone keyed by lower case zone name, zones without one get a new serial
+func constrcutZones(rz RawZones, serverName string, serials map[string]uint32) (zones, auth, error) {
+	a := make(auth)
+	z := make(zones)
+	// hosts can be in both a zone and its parent, the closest zone is used
+	// for the authority section
+	authZone := make(map[string]string)
+	for zoneName, hosts := range rz.Zones {
+		zoneName = dns.Fqdn(zoneName)
+		serial, present := serials[strings.ToLower(zoneName)]
+		if !present {
+			serial = newSerial(0)
+		}
+		soa, err := dns.NewRR(fmt.Sprintf("%s SOA %s dns.%s  %d 10000 2400 604800 3600", zoneName, serverName, serverName, serial))
+		if err != nil {
+			return nil, nil, err
+		}
+		// a parent zone in the same view may already have delegation records
+		// for the apex
+		if _, present := z[zoneName]; !present {
+			z[zoneName] = make(map[uint16][]dns.RR)
+		}
+		z[zoneName][dns.TypeSOA] = []dns.RR{soa}
+
+		authRR, err := dns.NewRR(fmt.Sprintf("%s NS %s", zoneName, serverName))
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to create authority NS record: %v", err)
+		}
+
+		for host, records := range hosts {
+			host = dns.Fqdn(host)
+			if _, present := z[host]; !present {
+				z[host] = make(map[uint16][]dns.RR)
+			}
+
+			for typeStr, v := range records {
+				rType, present := dns.StringToType[strings.ToUpper(typeStr)]
+				if !present {
+					return nil, nil, fmt.Errorf("Invalid record type")
+				}
+
+				for _, presentation := range v {
+					rr, err := newRecord(host, typeStr, presentation)
+					if err != nil {
+						return nil, nil, err
+					}
+					if !containsRR(z[host][rType], rr) {
+						z[host][rType] = append(z[host][rType], rr)
+					}
+					if len(zoneName) > len(authZone[host]) {
+						authZone[host] = zoneName
+						a[host] = &authRR
+					}
+				}
+			}
+		}
+	}
+	return z, a, nil
+}
+
+// containsRR returns true if rr is already in records, e.g. when a parent
+// zone has the same NS records as its delegated child
+func containsRR(records []dns.RR, rr dns.RR) bool {
+	for _, r := range records {
+		if r.String() == rr.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseYAML parses a zone definition in the YAML zone file format
+func ParseYAML(content []byte) (RawZones, error) {
+	rz := RawZones{}
+	err := yaml.Unmarshal(content, &rz)
+	return rz, err
+}
+
+// LoadYAML reads and parses a YAML zone file
+func LoadYAML(filename string) (RawZones, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return RawZones{}, err
+	}
+	return ParseYAML(content)
+}